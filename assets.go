@@ -0,0 +1,165 @@
+package owl
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	assetHashLength   = 12
+	assetCacheControl = "public, max-age=31536000, immutable"
+)
+
+type asset struct {
+	url       string
+	integrity string
+}
+
+// AssetManifest knows the fingerprinted URL of every file mounted with
+// StaticAssets or StaticAssetsEmbedded. The fingerprint is a hash of the file
+// content, so the URL changes when the file changes and browsers can cache it
+// forever. Use it in your views adding its FuncMap to your templates:
+//
+//	templ := template.New("").Funcs(templ.CreateDefaultFuncMap()).Funcs(mux.Assets().FuncMap())
+//
+// Then:
+//
+//	<link rel="stylesheet" href="{{ Asset "css/site.css" }}" {{ AssetIntegrity "css/site.css" }}>
+type AssetManifest struct {
+	assets map[string]asset
+	mutex  sync.RWMutex
+}
+
+func newAssetManifest() *AssetManifest {
+	return &AssetManifest{
+		assets: make(map[string]asset),
+	}
+}
+
+// add hashes all the files of fsys and returns a map of the hashed paths to the
+// original ones. Precompressed ".gz" siblings are not added. Panics if a file with
+// the same name was added by other mount.
+func (manifest *AssetManifest) add(url string, fsys fs.FS) map[string]string {
+	hashed := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(name, precompressedExtension) {
+			return nil
+		}
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		contentHash := sha256.New()
+		integrityHash := sha512.New384()
+		if _, err := io.Copy(io.MultiWriter(contentHash, integrityHash), file); err != nil {
+			return err
+		}
+		fingerprint := hex.EncodeToString(contentHash.Sum(nil))[:assetHashLength]
+		hashedName := fingerprintName(name, fingerprint)
+		hashed["/"+hashedName] = "/" + name
+
+		manifest.mutex.Lock()
+		defer manifest.mutex.Unlock()
+		if existing, ok := manifest.assets[name]; ok {
+			return fmt.Errorf("asset '%s' is already mounted as '%s'", name, existing.url)
+		}
+		manifest.assets[name] = asset{
+			url:       strings.TrimSuffix(url, "/") + "/" + hashedName,
+			integrity: "sha384-" + base64.StdEncoding.EncodeToString(integrityHash.Sum(nil)),
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panicf("cannot create asset manifest for '%s': %s", url, err)
+	}
+	return hashed
+}
+
+// fingerprintName inserts the fingerprint before the file extension: "css/site.css"
+// becomes "css/site.<fingerprint>.css".
+func fingerprintName(name, fingerprint string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + fingerprint + ext
+}
+
+func (manifest *AssetManifest) get(name string) (asset, error) {
+	manifest.mutex.RLock()
+	defer manifest.mutex.RUnlock()
+	asset, ok := manifest.assets[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return asset, fmt.Errorf("asset '%s' not found", name)
+	}
+	return asset, nil
+}
+
+// URL returns the fingerprinted URL of an asset. For example, if "css/site.css" is
+// mounted in "/static", returns "/static/css/site.<hash>.css".
+func (manifest *AssetManifest) URL(name string) (string, error) {
+	asset, err := manifest.get(name)
+	if err != nil {
+		return "", err
+	}
+	return asset.url, nil
+}
+
+// Integrity returns the Subresource Integrity hash of an asset, like "sha384-...".
+func (manifest *AssetManifest) Integrity(name string) (string, error) {
+	asset, err := manifest.get(name)
+	if err != nil {
+		return "", err
+	}
+	return asset.integrity, nil
+}
+
+// FuncMap returns the template functions Asset, that returns the fingerprinted URL of
+// an asset, and AssetIntegrity, that returns its integrity attribute.
+func (manifest *AssetManifest) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"Asset": manifest.URL,
+		"AssetIntegrity": func(name string) (template.HTMLAttr, error) {
+			integrity, err := manifest.Integrity(name)
+			if err != nil {
+				return "", err
+			}
+			return template.HTMLAttr(`integrity="` + integrity + `"`), nil
+		},
+	}
+}
+
+// assetFileServer serves fingerprinted paths with the file of the original path
+// and a long cache lifetime. Original paths are served like any static file.
+type assetFileServer struct {
+	hashed map[string]string
+	files  http.Handler
+}
+
+func (server assetFileServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if original, ok := server.hashed[req.URL.Path]; ok {
+		req.URL.Path = original
+		w.Header().Set("Cache-Control", assetCacheControl)
+	}
+	server.files.ServeHTTP(w, req)
+}
+
+// Asset returns the fingerprinted URL of an asset. See AssetManifest.
+func (ctx Ctx) Asset(name string) (string, error) {
+	if ctx.assets == nil {
+		return "", fmt.Errorf("asset '%s' not found: no assets mounted", name)
+	}
+	return ctx.assets.URL(name)
+}
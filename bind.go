@@ -0,0 +1,309 @@
+package owl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/deltegui/owl/core"
+	"github.com/deltegui/valtruc"
+)
+
+// ConversionErrorIdentifier identifies the ModelState errors produced when a submitted
+// value cannot be converted to the type of the field. Localize it like any other
+// validation identifier.
+const ConversionErrorIdentifier valtruc.ValidatorIdentifier = "conversion"
+
+// Struct tags used by Bind to read values from URL query and URL params.
+const (
+	queryTag = "query"
+	paramTag = "param"
+	htmlTag  = "html"
+)
+
+// fieldSource returns the raw value for a name and if the value is present.
+type fieldSource func(name string) (string, bool)
+
+// Bind fills the struct pointed by dst with the request data and validates it. The
+// source of each field is selected this way:
+//
+//   - If the request Content-Type is Json, the body is decoded using the 'json' tags.
+//   - Otherwise, the form is parsed and the fields are filled like ParseForm does, using
+//     the 'html' tag or the field name.
+//   - Fields with a 'query' tag are filled with the URL query param with that name.
+//   - Fields with a 'param' tag are filled with the URL param with that name.
+//
+// For example:
+//
+//	type editUser struct {
+//		Id    int64  `param:"id"`
+//		Page  int    `query:"page"`
+//		Name  string `html:"name" json:"name" valtruc:"min=3, required"`
+//	}
+//
+//	func handler(ctx owl.Ctx) error {
+//		var form editUser
+//		if err := ctx.Bind(&form); err != nil {
+//			return err
+//		}
+//		if !ctx.ModelState.Valid {
+//			...
+//		}
+//	}
+//
+// After binding, ctx.Validate is called. Values that cannot be converted to the field type
+// are added to ModelState with the identifier ConversionErrorIdentifier. Uploaded files
+// are bound like ParseForm does. Returns an error (a bad request HttpError) only if the
+// body cannot be read or decoded or the form has too many indexed elements, or a request
+// entity too large HttpError if the body is bigger than the multipart max size (see
+// Ctx.SetMultipartMaxSize). An invalid 'file' tag in dst is returned as an error too.
+func (ctx *Ctx) Bind(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot bind to %T: a pointer to a struct is required", dst)
+	}
+	e := v.Elem()
+
+	result := ctx.state.binding
+	result.reset()
+	if isJsonRequest(ctx.Req) {
+		if err := ctx.bindJson(dst, e, result); err != nil {
+			return err
+		}
+	} else {
+		if err := ctx.parseRequestForm(); err != nil {
+			return formParseError(err)
+		}
+		bindForm(e, requestFormData(ctx.Req), "", "", result)
+		if result.err != nil {
+			return result.err
+		}
+	}
+	bindFields(e, queryTag, querySource(ctx.Req), result)
+	bindFields(e, paramTag, ctx.paramSource(), result)
+
+	ctx.Validate(e.Interface())
+	return nil
+}
+
+// formParseError returns a request entity too large HttpError if the body exceeds
+// the multipart max size. Otherwise, a bad request HttpError.
+func formParseError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return NewHttpError(http.StatusRequestEntityTooLarge, fmt.Errorf("cannot parse form: %w", err))
+	}
+	return NewHttpError(http.StatusBadRequest, fmt.Errorf("cannot parse form: %w", err))
+}
+
+func (ctx *Ctx) bindJson(dst any, e reflect.Value, result *bindResult) error {
+	err := json.NewDecoder(ctx.Req.Body).Decode(dst)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		path, structName, fieldName := jsonFieldPath(e.Type(), typeErr.Field)
+		result.fail(path, newConversionError(structName, fieldName, typeErr.Type.String(), typeErr.Value))
+		return nil
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return NewHttpError(http.StatusRequestEntityTooLarge, fmt.Errorf("cannot decode json body: %w", err))
+	}
+	return NewHttpError(http.StatusBadRequest, fmt.Errorf("cannot decode json body: %w", err))
+}
+
+// jsonFieldPath converts the dotted Json path of a decoding error, like "address.city"
+// or "items.0.price", to the path of the Go field, like "Address.City" or "Items[0].Price".
+// Also returns the name of the innermost struct and field.
+func jsonFieldPath(t reflect.Type, jsonPath string) (path, structName, fieldName string) {
+	structName = t.Name()
+	known := true
+	for segment := range strings.SplitSeq(jsonPath, ".") {
+		for known && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if known && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+			path += "[" + segment + "]"
+			t = t.Elem()
+			continue
+		}
+		fieldName = segment
+		known = known && t.Kind() == reflect.Struct
+		if known {
+			if field, ok := fieldByJsonName(t, segment); ok {
+				structName = t.Name()
+				fieldName = field.Name
+				t = field.Type
+			} else {
+				known = false
+			}
+		}
+		if len(path) > 0 {
+			path += "."
+		}
+		path += fieldName
+	}
+	return path, structName, fieldName
+}
+
+func fieldByJsonName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == name || (len(tag) == 0 && strings.EqualFold(field.Name, name)) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func isJsonRequest(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func querySource(req *http.Request) fieldSource {
+	query := req.URL.Query()
+	return func(name string) (string, bool) {
+		if !query.Has(name) {
+			return "", false
+		}
+		return query.Get(name), true
+	}
+}
+
+func (ctx Ctx) paramSource() fieldSource {
+	return func(name string) (string, bool) {
+		for _, p := range ctx.params {
+			if p.Key == name {
+				return p.Value, true
+			}
+		}
+		return "", false
+	}
+}
+
+// bindFields sets the fields of the struct e that have the tag using the values
+// returned by source. Values that cannot be converted are added to failures.
+func bindFields(e reflect.Value, tag string, source fieldSource, result *bindResult) {
+	t := e.Type()
+	for i := range t.NumField() {
+		fieldValue := e.Field(i)
+		fieldType := t.Field(i)
+		lookup, ok := fieldType.Tag.Lookup(tag)
+		if !ok {
+			continue
+		}
+		if !fieldValue.IsValid() || !fieldValue.CanSet() {
+			continue
+		}
+		value, ok := source(lookup)
+		if !ok {
+			continue
+		}
+		result.raw[fieldType.Name] = value
+		format := fieldType.Tag.Get(formatTag)
+		if !result.converters.setValue(fieldValue, value, format) && len(value) > 0 && result.converters.supports(fieldType.Type) {
+			result.fail(fieldType.Name, newConversionError(t.Name(), fieldType.Name, fieldType.Type.String(), value))
+		}
+	}
+}
+
+func hasTag(field reflect.StructField, tag string) bool {
+	_, ok := field.Tag.Lookup(tag)
+	return ok
+}
+
+// ConversionError is the core.ValidationError added to ModelState when a submitted value
+// cannot be converted to the type of its field. Its identifier is ConversionErrorIdentifier,
+// so you can localize it adding a translation with the key "conversion". The localized
+// string receives the localized field name:
+//
+//	"conversion": "The value of %s is not valid"
+type ConversionError struct {
+	StructName    string
+	FieldName     string
+	FieldTypeName string
+	FieldValue    string
+}
+
+func newConversionError(structName, fieldName, typeName, value string) core.ValidationError {
+	return ConversionError{
+		StructName:    structName,
+		FieldName:     fieldName,
+		FieldTypeName: typeName,
+		FieldValue:    value,
+	}
+}
+
+func (err ConversionError) Error() string {
+	return fmt.Sprintf("cannot convert value '%s' of field '%s' to %s", err.FieldValue, err.FieldName, err.FieldTypeName)
+}
+
+// Format returns the localized message. If there is no translation, returns Error.
+func (err ConversionError) Format(f string) string {
+	if len(f) == 0 || strings.HasPrefix(f, string(ConversionErrorIdentifier)+"%!") {
+		return err.Error()
+	}
+	return f
+}
+
+func (err ConversionError) GetStructName() string    { return err.StructName }
+func (err ConversionError) GetFieldName() string     { return err.FieldName }
+func (err ConversionError) GetFieldTypeName() string { return err.FieldTypeName }
+func (err ConversionError) GetFieldValue() string    { return err.FieldValue }
+func (err ConversionError) GetIdentifier() valtruc.ValidatorIdentifier {
+	return ConversionErrorIdentifier
+}
+
+// bindResult stores the conversion errors and the raw values found while
+// binding a request, indexed by field path, and the converters used to bind
+// it. See Ctx.Validate for the format of paths. err is set if the target
+// struct cannot be bound, for example, because of an invalid 'file' tag.
+type bindResult struct {
+	errors     map[string][]core.ValidationError
+	raw        map[string]string
+	converters *converterRegistry
+	err        error
+}
+
+func newBindResult(converters *converterRegistry) *bindResult {
+	return &bindResult{
+		errors:     map[string][]core.ValidationError{},
+		raw:        map[string]string{},
+		converters: converters,
+	}
+}
+
+// reset removes the errors and raw values of a previous binding.
+func (result *bindResult) reset() {
+	clear(result.errors)
+	clear(result.raw)
+	result.err = nil
+}
+
+func (result *bindResult) fail(path string, err core.ValidationError) {
+	result.errors[path] = append(result.errors[path], err)
+}
+
+// addTo adds the conversion errors to a ModelState, making it invalid.
+func (result *bindResult) addTo(state *core.ModelState) {
+	if len(result.errors) == 0 {
+		return
+	}
+	if state.Errors == nil {
+		state.Errors = map[string][]core.ValidationError{}
+	}
+	state.Valid = false
+	for path, list := range result.errors {
+		state.Errors[path] = append(state.Errors[path], list...)
+	}
+}
@@ -0,0 +1,100 @@
+package owl
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckETag sets the ETag header of the response and tells if the client already has
+// this version of the resource, comparing the tag with the If-None-Match header. In
+// that case, not modified (304) is sent and you should return without rendering:
+//
+//	tag := fmt.Sprintf("user-%d-%d", user.Id, user.Version)
+//	if ctx.CheckETag(tag) {
+//		return nil
+//	}
+//	return ctx.Render(templ, "user", user)
+//
+// The tag is quoted if it is not. Use the prefix W/ for weak tags: W/"abc".
+// Only GET and HEAD requests are answered with 304.
+func (ctx Ctx) CheckETag(tag string) bool {
+	tag = quoteETag(tag)
+	ctx.Res.Header().Set("ETag", tag)
+	if !isConditionalMethod(ctx.Req.Method) {
+		return false
+	}
+	if !etagMatches(ctx.Req.Header.Get("If-None-Match"), tag) {
+		return false
+	}
+	ctx.writeNotModified()
+	return true
+}
+
+// NotModifiedSince sets the Last-Modified header of the response and tells if the
+// client already has the resource, comparing t with the If-Modified-Since header. In
+// that case, not modified (304) is sent and you should return without rendering:
+//
+//	if ctx.NotModifiedSince(post.UpdatedAt) {
+//		return nil
+//	}
+//	return ctx.Render(templ, "post", post)
+//
+// If-Modified-Since is ignored when the request has If-None-Match. Only GET and HEAD
+// requests are answered with 304.
+func (ctx Ctx) NotModifiedSince(t time.Time) bool {
+	if t.IsZero() || t.Unix() == 0 {
+		return false
+	}
+	ctx.Res.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	if !isConditionalMethod(ctx.Req.Method) || len(ctx.Req.Header.Get("If-None-Match")) > 0 {
+		return false
+	}
+	since, err := http.ParseTime(ctx.Req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	if t.Truncate(time.Second).After(since) {
+		return false
+	}
+	ctx.writeNotModified()
+	return true
+}
+
+// writeNotModified sends a not modified (304) response. Content headers are removed,
+// because the response has no body.
+func (ctx Ctx) writeNotModified() {
+	header := ctx.Res.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	ctx.Res.WriteHeader(http.StatusNotModified)
+}
+
+func isConditionalMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func quoteETag(tag string) string {
+	opaque, weak := strings.CutPrefix(tag, "W/")
+	if !strings.HasPrefix(opaque, `"`) || !strings.HasSuffix(opaque, `"`) || len(opaque) < 2 {
+		opaque = `"` + strings.Trim(opaque, `"`) + `"`
+	}
+	if weak {
+		return "W/" + opaque
+	}
+	return opaque
+}
+
+// etagMatches compares the If-None-Match header with a tag using the weak comparison,
+// so W/"abc" matches "abc".
+func etagMatches(ifNoneMatch, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
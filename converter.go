@@ -0,0 +1,218 @@
+package owl
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formatTag is the struct tag with the layout used to parse time.Time fields.
+const formatTag = "format"
+
+// timeLayouts are the layouts tried, in order, to parse time.Time fields without
+// a 'format' tag. They cover RFC3339 and the values sent by <input type="date">
+// and <input type="datetime-local">.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	enumType            = reflect.TypeFor[Enum]()
+)
+
+// Converter converts a submitted value to a custom type. The returned value must
+// be of the registered type (or convertible to it). See Mux.AddConverter.
+type Converter func(value string) (any, error)
+
+// Enum is implemented by named string types with a closed set of values. When
+// binding a form, values not returned by EnumValues are conversion errors. For example:
+//
+//	type Color string
+//
+//	func (Color) EnumValues() []string {
+//		return []string{"red", "green", "blue"}
+//	}
+type Enum interface {
+	EnumValues() []string
+}
+
+// converterRegistry stores the custom converters of a mux indexed by type.
+type converterRegistry struct {
+	mutex      sync.RWMutex
+	converters map[reflect.Type]Converter
+}
+
+func newConverterRegistry() *converterRegistry {
+	return &converterRegistry{
+		converters: map[reflect.Type]Converter{},
+	}
+}
+
+func (registry *converterRegistry) add(t reflect.Type, converter Converter) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.converters[t] = converter
+}
+
+func (registry *converterRegistry) get(t reflect.Type) (Converter, bool) {
+	if registry == nil {
+		return nil, false
+	}
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	converter, ok := registry.converters[t]
+	return converter, ok
+}
+
+// supports tells if a string can be converted to the type. The supported types are the
+// ones with a registered converter, time.Time, types implementing encoding.TextUnmarshaler
+// and all types (named or not) of kind string, bool, int, uint and float. Pointers to these
+// types are supported too.
+func (registry *converterRegistry) supports(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := registry.get(t); ok {
+		return true
+	}
+	if t == timeType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8,
+		reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8,
+		reflect.Float64, reflect.Float32:
+		return true
+	default:
+		return false
+	}
+}
+
+// setValue converts the value to the type of the field and sets it. Empty values
+// leave pointer fields untouched. format is the layout for time.Time fields, if empty
+// timeLayouts are used. Returns false if the value cannot be converted.
+func (registry *converterRegistry) setValue(field reflect.Value, value, format string) bool {
+	t := field.Type()
+	isPointer := t.Kind() == reflect.Ptr
+	if isPointer {
+		if value == "" {
+			return true
+		}
+		t = t.Elem()
+	}
+	v, err := registry.convert(t, value, format)
+	if err != nil {
+		return false
+	}
+	if isPointer {
+		p := reflect.New(t)
+		p.Elem().Set(v)
+		field.Set(p)
+	} else {
+		field.Set(v)
+	}
+	return true
+}
+
+func (registry *converterRegistry) convert(t reflect.Type, value, format string) (reflect.Value, error) {
+	if converter, ok := registry.get(t); ok {
+		result, err := converter(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.ValueOf(result)
+		if !v.IsValid() || !v.Type().ConvertibleTo(t) {
+			return reflect.Value{}, fmt.Errorf("converter for %s returned %T", t, result)
+		}
+		return v.Convert(t), nil
+	}
+	if t == timeType {
+		date, err := parseTime(value, format)
+		return reflect.ValueOf(date), err
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		v := reflect.New(t)
+		err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+		return v.Elem(), err
+	}
+
+	var v any
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		v, err = value, checkEnum(t, value)
+	case reflect.Bool:
+		v, err = parseBool(value)
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		v, err = strconv.ParseInt(value, 0, t.Bits())
+	case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		v, err = strconv.ParseUint(value, 0, t.Bits())
+	case reflect.Float64, reflect.Float32:
+		v, err = parseDecimal(value, t.Bits())
+	default:
+		err = fmt.Errorf("unsupported type %s", t)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(v).Convert(t), nil
+}
+
+func parseTime(value, format string) (time.Time, error) {
+	if len(format) > 0 {
+		return time.Parse(format, value)
+	}
+	var err error
+	for _, layout := range timeLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseBool parses the values accepted by strconv.ParseBool and the values
+// sent by checkboxes without a value attribute: "on" and "off".
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// parseDecimal parses floats written with a dot or a comma as decimal separator,
+// like "1234.5" or "1234,5". Thousands separators are not supported: values with
+// more than one separator, like "1,234.5" or "1.234.567", are rejected, and a single
+// separator is always the decimal one, so "1,234" is 1.234.
+func parseDecimal(value string, bits int) (float64, error) {
+	if strings.Count(value, ".")+strings.Count(value, ",") > 1 {
+		return 0, fmt.Errorf("invalid decimal '%s': only one decimal separator is allowed", value)
+	}
+	return strconv.ParseFloat(strings.Replace(value, ",", ".", 1), bits)
+}
+
+func checkEnum(t reflect.Type, value string) error {
+	if !t.Implements(enumType) {
+		return nil
+	}
+	enum := reflect.Zero(t).Interface().(Enum)
+	if !slices.Contains(enum.EnumValues(), value) {
+		return fmt.Errorf("'%s' is not a valid %s", value, t)
+	}
+	return nil
+}
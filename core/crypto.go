@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"log"
 	"math/big"
+	"time"
 )
 
 // DefaultTokenBytes defines the default
@@ -22,6 +23,37 @@ func GenerateToken(numberBytes int) string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
+// sortableIdEncoding is the Crockford base32 alphabet. Its characters are
+// sorted, so the encoded ids keep the order of the bytes.
+const sortableIdEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Generates a lexicographically sortable unique id, like ULID. The id has 26
+// characters: a millisecond timestamp (48 bits) followed by 80 crypto random bits,
+// encoded in Crockford base32. Ids generated later sort after the previous ones
+// (with millisecond precision).
+func GenerateSortableId() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	for i := range 6 {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	if _, err := rand.Read(b[6:]); err != nil {
+		log.Panicln("Error while generating random id: ", err)
+	}
+	// 128 bits encoded in 26 characters of 5 bits. The first character has only 3 bits.
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 |
+		uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	id := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		id[i] = sortableIdEncoding[lo&0x1F]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id)
+}
+
 // Generates a crypto random token with the default length.
 // See the function "GenerateToken" and the constant "DefaultTokenBytes"
 func GenerateTokenDefaultLength() string {
@@ -0,0 +1,27 @@
+package core
+
+import "net/http"
+
+// ForwardedKey is the context key of the Forwarded values of the request.
+const ForwardedKey string = "phx_forwarded"
+
+// Forwarded are the values of the original client request, before it was
+// forwarded by a trusted reverse proxy. Scheme and Host are empty if the proxy
+// did not send them.
+type Forwarded struct {
+	ClientIP string
+	Scheme   string
+	Host     string
+}
+
+// Scheme returns "https" or "http" depending on the protocol used by the client.
+// Behind a trusted reverse proxy, the scheme sent by the proxy is used.
+func Scheme(req *http.Request) string {
+	if forwarded, ok := req.Context().Value(ForwardedKey).(Forwarded); ok && len(forwarded.Scheme) > 0 {
+		return forwarded.Scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
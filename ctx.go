@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,6 +20,10 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// CspNonceKey is the context key of the Content-Security-Policy nonce of the
+// request. See Ctx.CspNonce.
+const CspNonceKey string = "phx_csp_nonce"
+
 // Ctx represents the request Context. This includes:
 // - http.Request
 // - http.ResponseWriter
@@ -31,18 +36,56 @@ type Ctx struct {
 	Req    *http.Request
 	Res    http.ResponseWriter
 	params httprouter.Params
-	ctx    context.Context
+	state  *ctxState
 
 	ModelState core.ModelState
 	validator  valtruc.Valtruc
 	locstore   *localizer.WebStore
 	cypher     core.Cypher
+	routes     *routeTable
+	assets     *AssetManifest
+	pattern    string
+
+	errorHandler ErrorHandler
 
 	Logger logx.Logger
 }
 
+// ctxState is shared by all copies of the Ctx of a request. This way, values
+// set by inner middlewares or handlers are also visible to outer middlewares.
+type ctxState struct {
+	ctx              context.Context
+	keys             []any
+	binding          *bindResult
+	multipartMaxSize int64
+	response         *ResponseWriter
+	closers          []func()
+}
+
+// onClose registers a function called when the handler returns, before the response ends.
+func (state *ctxState) onClose(fn func()) {
+	state.closers = append(state.closers, fn)
+}
+
+func (state *ctxState) close() {
+	closers := state.closers
+	state.closers = nil
+	for _, fn := range slices.Backward(closers) {
+		fn()
+	}
+}
+
+func newCtxState(ctx context.Context, response *ResponseWriter, converters *converterRegistry) *ctxState {
+	return &ctxState{
+		ctx:      ctx,
+		binding:  newBindResult(converters),
+		response: response,
+	}
+}
+
 // Validates a struct. Will populate ModelState telling if
-// struct is valid and the errors found.
+// struct is valid and the errors found. The conversion errors
+// found by ParseForm or Bind are also kept in ModelState.
 func (ctx *Ctx) Validate(target any) {
 	errs := ctx.validator.Validate(target)
 	state := core.ModelState{
@@ -51,6 +94,7 @@ func (ctx *Ctx) Validate(target any) {
 
 	if len(errs) == 0 {
 		state.Valid = true
+		ctx.state.binding.addTo(&state)
 		ctx.ModelState = state
 		return
 	}
@@ -66,33 +110,91 @@ func (ctx *Ctx) Validate(target any) {
 		state.Errors[fieldname] = append(state.Errors[fieldname], valtrucErr)
 	}
 
+	ctx.state.binding.addTo(&state)
 	ctx.ModelState = state
 }
 
 // Set a variable in the context.Context.
 func (ctx *Ctx) Set(key, value any) {
-	ctx.ctx = context.WithValue(ctx.ctx, key, value)
+	ctx.state.ctx = context.WithValue(ctx.state.ctx, key, value)
+	if !slices.Contains(ctx.state.keys, key) {
+		ctx.state.keys = append(ctx.state.keys, key)
+	}
 }
 
 // Get a value identified by key in context.Context.
 func (ctx Ctx) Get(key any) any {
-	return ctx.ctx.Value(key)
+	return ctx.state.ctx.Value(key)
+}
+
+// Values returns all the values stored using Set.
+func (ctx Ctx) Values() map[any]any {
+	values := make(map[any]any, len(ctx.state.keys))
+	for _, key := range ctx.state.keys {
+		values[key] = ctx.state.ctx.Value(key)
+	}
+	return values
 }
 
 // Return current request context.Context
 func (ctx Ctx) Context() context.Context {
-	return ctx.ctx
+	return ctx.state.ctx
+}
+
+// SetContext replaces the request context.Context. Use a context derived from
+// ctx.Context() to keep the values stored with Set. For example, to add a deadline:
+//
+//	timeoutCtx, cancel := context.WithTimeout(ctx.Context(), time.Second)
+//	defer cancel()
+//	ctx.SetContext(timeoutCtx)
+func (ctx Ctx) SetContext(c context.Context) {
+	ctx.state.ctx = c
+}
+
+// RequestId returns the id of the request set by middleware.RequestId or empty.
+func (ctx Ctx) RequestId() string {
+	return logx.RequestId(ctx.Context())
+}
+
+// CspNonce returns the Content-Security-Policy nonce of the request set by
+// middleware.SecureHeaders or empty. Use it in the nonce attribute of inline
+// scripts and styles.
+func (ctx Ctx) CspNonce() string {
+	nonce, _ := ctx.Get(CspNonceKey).(string)
+	return nonce
+}
+
+// RoutePattern returns the pattern of the route that matched the request, with the
+// prefix of its sub mux. For example: "/users/:id". Its empty for NotFound and
+// MethodNotAllowed handlers.
+func (ctx Ctx) RoutePattern() string {
+	return ctx.pattern
+}
+
+// HandleError sends the error to the ErrorHandler of the mux right now, instead of
+// waiting for the handler chain to return it. Middlewares that need to know the final
+// response, like middleware.Logger and middleware.AccessLog, use it and then return nil.
+func (ctx Ctx) HandleError(err error) {
+	if ctx.errorHandler != nil {
+		ctx.errorHandler(ctx, err)
+	}
+}
+
+// URL generates the URL of a named route. The params fill, in order, the
+// ':param' and '*catchall' segments of the route pattern. See Mux.URL.
+func (ctx Ctx) URL(name string, params ...any) (string, error) {
+	return ctx.routes.url(name, params...)
 }
 
 // Redirects to other URL with HTTP code 307 (temporary redirect).
 func (ctx Ctx) Redirect(to string) error {
-	http.Redirect(ctx.Res, ctx.Req, to, http.StatusTemporaryRedirect)
-	return nil
+	return ctx.RedirectCode(to, http.StatusTemporaryRedirect)
 }
 
-// Redirect to other URL with the provided status code.
+// Redirect to other URL with the provided status code. Behind a trusted proxy (see
+// middleware.TrustedProxies) paths are redirected using the client Scheme and Host.
 func (ctx Ctx) RedirectCode(to string, code int) error {
-	http.Redirect(ctx.Res, ctx.Req, to, code)
+	http.Redirect(ctx.Res, ctx.Req, ctx.redirectLocation(to), code)
 	return nil
 }
 
@@ -111,6 +213,15 @@ func (ctx Ctx) GetURLParam(name string) string {
 	return ctx.params.ByName(name)
 }
 
+// GetURLParams returns all URL params of the request. See GetURLParam.
+func (ctx Ctx) GetURLParams() map[string]string {
+	params := make(map[string]string, len(ctx.params))
+	for _, p := range ctx.params {
+		params[p.Key] = p.Value
+	}
+	return params
+}
+
 // Get query URL param. For example:
 //
 // /index?first=hello&second=hola
@@ -364,6 +475,7 @@ type CookieOptions struct {
 
 	// Sets if cookies are only send through https.
 	// If its true means https only. By default false.
+	// CreateCookie enables it when the client uses https (see Ctx.Scheme).
 	Secure bool
 }
 
@@ -390,14 +502,14 @@ func (ctx *Ctx) CreateCookieOptions(opt CookieOptions) error {
 }
 
 // Creates a cookie with name and data. By default uses a one day duration expiration,
-// HttpOnly enabled, and Secure enabled.
+// HttpOnly enabled, and Secure enabled if the client uses https.
 func (ctx *Ctx) CreateCookie(name, data string) error {
 	return ctx.CreateCookieOptions(CookieOptions{
 		Name:     name,
 		Expires:  core.OneDayDuration,
 		Value:    data,
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   ctx.Scheme() == "https",
 	})
 }
 
@@ -0,0 +1,203 @@
+package owl
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/deltegui/owl/core"
+)
+
+// ErrorHandler is the function that receives every error returned by a Handler.
+// It decides which status code is sent to the client, logs the error and renders
+// an error page. You can replace the default one using SetErrorHandler:
+//
+//	mux.SetErrorHandler(owl.NewErrorHandler(owl.ErrorHandlerOptions{
+//		Template:     templ,
+//		TemplateName: "ErrorView",
+//	}))
+//
+// If the handler already wrote the response (see Ctx.Written) the error can only be logged.
+type ErrorHandler func(ctx Ctx, err error)
+
+// HttpError is an error that knows which HTTP status code must be
+// sent to the client. Return it from a handler or a middleware to
+// let the ErrorHandler use that status:
+//
+//	return owl.NewHttpError(http.StatusForbidden, err)
+type HttpError struct {
+	Status  int
+	wrapped error
+}
+
+// Creates a new HttpError with a status code that wraps other error.
+func NewHttpError(status int, err error) HttpError {
+	return HttpError{
+		Status:  status,
+		wrapped: err,
+	}
+}
+
+func (err HttpError) Error() string {
+	if err.wrapped != nil {
+		return fmt.Sprintf("%d %s: %s", err.Status, http.StatusText(err.Status), err.wrapped.Error())
+	}
+	return fmt.Sprintf("%d %s", err.Status, http.StatusText(err.Status))
+}
+
+func (err HttpError) Unwrap() error {
+	return err.wrapped
+}
+
+// ErrorPage is the model passed to error templates.
+type ErrorPage struct {
+	Status  int    `json:"status"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+
+	// Details contains the raw error message. Its only filled
+	// when ErrorHandlerOptions.ShowDetails is enabled.
+	Details string `json:"details,omitempty"`
+}
+
+// ErrorHandlerOptions configures the ErrorHandler created by NewErrorHandler.
+type ErrorHandlerOptions struct {
+	// Template used to render HTML error pages. The view receives
+	// a ViewModel with an ErrorPage as Model. If its nil a minimal
+	// built-in page is used.
+	Template     *template.Template
+	TemplateName string
+
+	// DomainCodes maps core.DomainError codes to HTTP status codes.
+	// See ErrorStatus for the default mapping.
+	DomainCodes map[int]int
+
+	// ShowDetails writes the raw error message in the error page.
+	// Only enable it in development.
+	ShowDetails bool
+}
+
+// DefaultErrorHandler is the ErrorHandler used by default. See NewErrorHandler.
+func DefaultErrorHandler(ctx Ctx, err error) {
+	NewErrorHandler(ErrorHandlerOptions{})(ctx, err)
+}
+
+// NewErrorHandler creates an ErrorHandler. The handler will:
+//
+//   - Resolve the status code using ErrorStatus.
+//   - Log the error using ctx.Logger. Server errors (5xx) are logged as errors, others as warnings.
+//   - Render a Json error if the client accepts Json. Otherwise an HTML page is rendered.
+//
+// Messages of core.DomainError are localized using ctx.LocalizeError. Any other server error
+// is shown with a generic message to avoid leaking internal information.
+func NewErrorHandler(opt ErrorHandlerOptions) ErrorHandler {
+	return func(ctx Ctx, err error) {
+		status := ErrorStatus(err, opt.DomainCodes)
+		logError(ctx, status, err)
+		if ctx.Written() {
+			// The handler already started the response. Writing the error page
+			// would append it to the body.
+			return
+		}
+
+		page := createErrorPage(ctx, status, err, opt.ShowDetails)
+		if wantsJson(ctx.Req) {
+			ctx.Status(status)
+			if err := ctx.Json(page); err != nil {
+				ctx.Logger.ErrorContext(ctx.Context(), "Error while writing error response", "err", err)
+			}
+			return
+		}
+
+		ctx.Res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		ctx.Status(status)
+		var renderErr error
+		if opt.Template != nil {
+			renderErr = ctx.Render(opt.Template, opt.TemplateName, page)
+		} else {
+			renderErr = defaultErrorTemplate.Execute(ctx.Res, page)
+		}
+		if renderErr != nil {
+			ctx.Logger.ErrorContext(ctx.Context(), "Error while rendering error page", "err", renderErr)
+		}
+	}
+}
+
+// ErrorStatus resolves the HTTP status code for an error. The mapping is:
+//
+//   - HttpError uses its own Status.
+//   - core.DomainError uses the status registered in domainCodes for its Code. If there is
+//     none and the Code is a valid HTTP error status (4xx or 5xx) the Code is used. Otherwise
+//     is considered a bad request (400).
+//   - Any other error is an internal server error (500).
+func ErrorStatus(err error, domainCodes map[int]int) int {
+	var httpErr HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status
+	}
+	var domainErr core.DomainError
+	if errors.As(err, &domainErr) {
+		if status, ok := domainCodes[domainErr.Code]; ok {
+			return status
+		}
+		if domainErr.Code >= http.StatusBadRequest && domainErr.Code < 600 {
+			return domainErr.Code
+		}
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func logError(ctx Ctx, status int, err error) {
+	args := []any{
+		"status", status,
+		"method", ctx.Req.Method,
+		"uri", ctx.Req.RequestURI,
+		"err", err,
+	}
+	if status >= http.StatusInternalServerError {
+		ctx.Logger.ErrorContext(ctx.Context(), "Handler returned an error", args...)
+	} else {
+		ctx.Logger.WarnContext(ctx.Context(), "Handler returned an error", args...)
+	}
+}
+
+func createErrorPage(ctx Ctx, status int, err error, showDetails bool) ErrorPage {
+	page := ErrorPage{
+		Status:  status,
+		Title:   http.StatusText(status),
+		Message: http.StatusText(status),
+	}
+	var domainErr core.DomainError
+	if errors.As(err, &domainErr) {
+		page.Message = ctx.LocalizeError(domainErr)
+	}
+	if showDetails {
+		page.Details = err.Error()
+	}
+	return page
+}
+
+func wantsJson(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return true
+	}
+	return len(accept) == 0 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+}
+
+var defaultErrorTemplate = template.Must(template.New("owl_error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{ .Status }} {{ .Title }}</title>
+</head>
+<body>
+	<h1>{{ .Status }} {{ .Title }}</h1>
+	<p>{{ .Message }}</p>
+	{{ if .Details }}<pre>{{ .Details }}</pre>{{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,47 @@
+package owl
+
+import (
+	"net/http"
+	"path"
+)
+
+// notFoundFallback is the handler used when no route matches a request. First,
+// tries to serve a static file (see Mux.Static). If there is no static file
+// system or the file does not exist, the NotFound handler is used.
+type notFoundFallback struct {
+	root     http.FileSystem
+	static   http.Handler
+	notFound http.Handler
+}
+
+// setStatic sets the file system served by the fallback. Static files can be
+// requested from any origin.
+func (fallback *notFoundFallback) setStatic(root http.FileSystem) {
+	files := newStaticFileServer(root)
+	fallback.root = root
+	fallback.static = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		files.ServeHTTP(w, r)
+	})
+}
+
+func (fallback notFoundFallback) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if fallback.static != nil && (fallback.notFound == nil || staticFileExists(fallback.root, req.URL.Path)) {
+		fallback.static.ServeHTTP(w, req)
+		return
+	}
+	if fallback.notFound != nil {
+		fallback.notFound.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func staticFileExists(root http.FileSystem, name string) bool {
+	file, err := root.Open(path.Clean("/" + name))
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
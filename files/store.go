@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"mime/multipart"
 	"os"
 	"path/filepath"
 	"strings"
@@ -49,6 +50,35 @@ func (s Store) Save(buffer []byte, relativePath string) (string, error) {
 	return s.generateURL(relativePath), nil
 }
 
+// Saves the content of a reader in a file located in a relative path. The content is
+// streamed, so its not loaded in memory. Returns a string with a URL to access the file.
+func (s Store) SaveReader(reader io.Reader, relativePath string) (string, error) {
+	file, fullPath, err := s.createFile(relativePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return "", fmt.Errorf("error writing to file with path: '%s'. Error: %w", fullPath, err)
+	}
+
+	return s.generateURL(relativePath), nil
+}
+
+// Saves an uploaded file in a relative path. Returns a string with a URL to access the file.
+// For example:
+//
+//	url, err := store.SaveFile(form.Avatar, fmt.Sprintf("avatars/%d.png", user.Id))
+func (s Store) SaveFile(header *multipart.FileHeader, relativePath string) (string, error) {
+	upload, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("cannot open uploaded file '%s'. Error: %w", header.Filename, err)
+	}
+	defer upload.Close()
+	return s.SaveReader(upload, relativePath)
+}
+
 func (s Store) generateURL(relativePath string) string {
 	return fmt.Sprintf("%s/%s", s.url, relativePath)
 }
@@ -0,0 +1,76 @@
+package owl
+
+import (
+	"net"
+	"strings"
+
+	"github.com/deltegui/owl/core"
+)
+
+// ForwardedKey is the context key of the Forwarded values of the request. See
+// middleware.TrustedProxies.
+const ForwardedKey string = core.ForwardedKey
+
+// Forwarded are the values of the original client request, before it was
+// forwarded by a trusted reverse proxy. See core.Forwarded.
+type Forwarded = core.Forwarded
+
+func (ctx Ctx) forwarded() (Forwarded, bool) {
+	forwarded, ok := ctx.Get(ForwardedKey).(Forwarded)
+	return forwarded, ok
+}
+
+// ClientIP returns the IP of the client. Behind a reverse proxy, use the
+// middleware.TrustedProxies to get the real client IP instead of the proxy one.
+func (ctx Ctx) ClientIP() string {
+	if forwarded, ok := ctx.forwarded(); ok && len(forwarded.ClientIP) > 0 {
+		return forwarded.ClientIP
+	}
+	host, _, err := net.SplitHostPort(ctx.Req.RemoteAddr)
+	if err != nil {
+		return ctx.Req.RemoteAddr
+	}
+	return host
+}
+
+// Scheme returns "https" or "http" depending on the protocol used by the client. See
+// ClientIP.
+func (ctx Ctx) Scheme() string {
+	if forwarded, ok := ctx.forwarded(); ok && len(forwarded.Scheme) > 0 {
+		return forwarded.Scheme
+	}
+	if ctx.Req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host requested by the client. See ClientIP.
+func (ctx Ctx) Host() string {
+	if forwarded, ok := ctx.forwarded(); ok && len(forwarded.Host) > 0 {
+		return forwarded.Host
+	}
+	return ctx.Req.Host
+}
+
+// AbsoluteURL returns the absolute URL of a path using the Scheme and Host of the
+// request. For example, "/account/verify" becomes "https://example.com/account/verify".
+func (ctx Ctx) AbsoluteURL(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return ctx.Scheme() + "://" + ctx.Host() + path
+}
+
+// redirectLocation makes absolute the paths of redirects behind a trusted proxy,
+// so the client is sent to its own scheme and host.
+func (ctx Ctx) redirectLocation(to string) string {
+	forwarded, ok := ctx.forwarded()
+	if !ok || (len(forwarded.Scheme) == 0 && len(forwarded.Host) == 0) {
+		return to
+	}
+	if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") {
+		return to
+	}
+	return ctx.AbsoluteURL(to)
+}
@@ -0,0 +1,163 @@
+package owl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook is a function executed when the server starts or stops. See Mux.OnStart
+// and Mux.OnShutdown. The context.Context is cancelled when the hook timeout expires.
+// Then the hook fails with a timeout error, even if it keeps running.
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	name    string
+	fn      Hook
+	timeout time.Duration
+	// order is the registration order among start and shutdown hooks.
+	order int
+}
+
+// run executes the hook. If the hook has a timeout and does not return in time, run
+// returns without waiting for it, so a hook ignoring its ctx cannot block the server.
+func (h hook) run(ctx context.Context) error {
+	if h.timeout <= 0 {
+		return h.wrapError(h.fn(ctx))
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- h.fn(ctx)
+	}()
+	select {
+	case err := <-result:
+		return h.wrapError(err)
+	case <-ctx.Done():
+		return fmt.Errorf("hook %s timed out: %w", h.name, ctx.Err())
+	}
+}
+
+func (h hook) wrapError(err error) error {
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %w", h.name, err)
+	}
+	return nil
+}
+
+// lifecycle stores the start and shutdown hooks and the draining state of the server.
+type lifecycle struct {
+	mutex         sync.Mutex
+	startHooks    []hook
+	shutdownHooks []hook
+	registered    int
+	draining      atomic.Bool
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{}
+}
+
+func (lc *lifecycle) onStart(fn Hook, timeout time.Duration) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.registered++
+	lc.startHooks = append(lc.startHooks, hook{funcName(fn), fn, timeout, lc.registered})
+}
+
+func (lc *lifecycle) onShutdown(fn Hook, timeout time.Duration) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.registered++
+	lc.shutdownHooks = append(lc.shutdownHooks, hook{funcName(fn), fn, timeout, lc.registered})
+}
+
+func (lc *lifecycle) isDraining() bool {
+	return lc.draining.Load()
+}
+
+// runStart runs the start hooks in registration order. Stops at the first error. If a
+// hook fails, the shutdown hooks registered before it are executed, so the resources
+// opened by the previous hooks are released.
+func (lc *lifecycle) runStart() error {
+	lc.mutex.Lock()
+	hooks := slices.Clone(lc.startHooks)
+	lc.mutex.Unlock()
+	for _, h := range hooks {
+		if err := h.run(context.Background()); err != nil {
+			return errors.Join(err, lc.runShutdown(h.order))
+		}
+	}
+	return nil
+}
+
+// runShutdown runs the shutdown hooks registered before the order in reverse registration
+// order, like defer does. A failing hook does not stop the others.
+func (lc *lifecycle) runShutdown(before int) error {
+	lc.mutex.Lock()
+	hooks := slices.Clone(lc.shutdownHooks)
+	lc.mutex.Unlock()
+	var errs []error
+	for _, h := range slices.Backward(hooks) {
+		if h.order > before {
+			continue
+		}
+		if err := h.run(context.Background()); err != nil {
+			log.Println("Error while running shutdown hook: ", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run executes the start hooks and then serve. When ctx is done the server enters
+// the draining state for drainDelay before serve is stopped. Finally, the shutdown
+// hooks are executed.
+func (lc *lifecycle) run(ctx context.Context, drainDelay time.Duration, serve func(ctx context.Context) error) error {
+	if err := lc.runStart(); err != nil {
+		return err
+	}
+	lc.draining.Store(false)
+
+	serveCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-serveCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		lc.draining.Store(true)
+		if drainDelay > 0 {
+			log.Printf("Server draining for %s\n", drainDelay)
+			select {
+			case <-time.After(drainDelay):
+			case <-serveCtx.Done():
+			}
+		}
+		cancel()
+	}()
+
+	err := serve(serveCtx)
+	cancel()
+	return errors.Join(err, lc.runShutdown(math.MaxInt))
+}
+
+// healthHandler responds with service unavailable (503) while draining. Otherwise with Ok (200).
+func (lc *lifecycle) healthHandler() Handler {
+	return func(ctx Ctx) error {
+		if lc.isDraining() {
+			ctx.Status(http.StatusServiceUnavailable)
+			return ctx.Json(map[string]string{"status": "draining"})
+		}
+		return ctx.JsonOk(map[string]string{"status": "ok"})
+	}
+}
@@ -15,6 +15,27 @@ const (
 	LevelError Level = 8
 )
 
+// RequestIdKey is the context.Context key of the request id. When a *Context
+// method receives a context.Context with a request id, the id is added to
+// the log with the "requestId" key. See middleware.RequestId.
+const RequestIdKey string = "phx_request_id"
+
+// RequestId returns the request id stored in the context.Context or empty.
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(RequestIdKey).(string)
+	return id
+}
+
+func withRequestId(ctx context.Context, args []any) []any {
+	if id := RequestId(ctx); len(id) > 0 {
+		return append(args[:len(args):len(args)], "requestId", id)
+	}
+	return args
+}
+
 type Logger interface {
 	WithModuleName(name string) Logger
 
@@ -48,7 +69,7 @@ func (logger SlogLogger) Info(msg string, args ...any) {
 }
 
 func (logger SlogLogger) InfoContext(ctx context.Context, msg string, args ...any) {
-	logger.slog.InfoContext(ctx, msg, args...)
+	logger.slog.InfoContext(ctx, msg, withRequestId(ctx, args)...)
 }
 
 func (logger SlogLogger) Warn(msg string, args ...any) {
@@ -56,7 +77,7 @@ func (logger SlogLogger) Warn(msg string, args ...any) {
 }
 
 func (logger SlogLogger) WarnContext(ctx context.Context, msg string, args ...any) {
-	logger.slog.WarnContext(ctx, msg, args...)
+	logger.slog.WarnContext(ctx, msg, withRequestId(ctx, args)...)
 }
 
 func (logger SlogLogger) Error(msg string, args ...any) {
@@ -64,7 +85,7 @@ func (logger SlogLogger) Error(msg string, args ...any) {
 }
 
 func (logger SlogLogger) ErrorContext(ctx context.Context, msg string, args ...any) {
-	logger.slog.ErrorContext(ctx, msg, args...)
+	logger.slog.ErrorContext(ctx, msg, withRequestId(ctx, args)...)
 }
 
 func (logger SlogLogger) Debug(msg string, args ...any) {
@@ -72,7 +93,7 @@ func (logger SlogLogger) Debug(msg string, args ...any) {
 }
 
 func (logger SlogLogger) DebugContext(ctx context.Context, msg string, args ...any) {
-	logger.slog.DebugContext(ctx, msg, args...)
+	logger.slog.DebugContext(ctx, msg, withRequestId(ctx, args)...)
 }
 
 type Default struct {
@@ -115,7 +136,7 @@ func (logger Default) Info(msg string, args ...any) {
 }
 
 func (logger Default) InfoContext(ctx context.Context, msg string, args ...any) {
-	logger.Info(msg, args...)
+	logger.Info(msg, withRequestId(ctx, args)...)
 }
 
 func (logger Default) Warn(msg string, args ...any) {
@@ -126,7 +147,7 @@ func (logger Default) Warn(msg string, args ...any) {
 }
 
 func (logger Default) WarnContext(ctx context.Context, msg string, args ...any) {
-	logger.Warn(msg, args...)
+	logger.Warn(msg, withRequestId(ctx, args)...)
 }
 
 func (logger Default) Error(msg string, args ...any) {
@@ -137,7 +158,7 @@ func (logger Default) Error(msg string, args ...any) {
 }
 
 func (logger Default) ErrorContext(ctx context.Context, msg string, args ...any) {
-	logger.Error(msg, args...)
+	logger.Error(msg, withRequestId(ctx, args)...)
 }
 
 func (logger Default) Debug(msg string, args ...any) {
@@ -149,7 +170,7 @@ func (logger Default) Debug(msg string, args ...any) {
 }
 
 func (logger Default) DebugContext(ctx context.Context, msg string, args ...any) {
-	logger.Debug(msg, args...)
+	logger.Debug(msg, withRequestId(ctx, args)...)
 }
 
 func Err(err error) slog.Attr {
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deltegui/owl"
+	"github.com/deltegui/owl/session"
+)
+
+const apacheTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessLogOptions struct {
+	// Writer receives a line for each request in Apache combined log format.
+	// Optional. See OpenAccessLogFile.
+	Writer io.Writer
+
+	// SkipPaths are not logged. A path ending with '*' skips all the paths with
+	// that prefix. For example: []string{"/health", "/static/*"}
+	SkipPaths []string
+
+	// SlowThreshold logs requests that take longer with warning level. Zero disables it.
+	SlowThreshold time.Duration
+}
+
+// AccessLogDefault is an AccessLog middleware without file writer, skip paths nor slow threshold.
+func AccessLogDefault() owl.Middleware {
+	return AccessLog(AccessLogOptions{})
+}
+
+// OpenAccessLogFile opens (or creates) a file to append access log lines. Use it as
+// AccessLogOptions.Writer and close it when the server stops:
+//
+//	file, err := middleware.OpenAccessLogFile("/var/log/app/access.log")
+//	if err != nil {
+//		log.Fatalln(err)
+//	}
+//	mux.OnShutdown(func(ctx context.Context) error { return file.Close() }, 0)
+//	mux.Use(middleware.AccessLog(middleware.AccessLogOptions{Writer: file}))
+func OpenAccessLogFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open access log file '%s': %w", path, err)
+	}
+	return file, nil
+}
+
+// AccessLog logs every request after the response is sent, using the ctx.Logger with
+// these fields: method, uri, route, status, bytes, duration, address, agent and user.
+// The logger adds the request id if there is one (see RequestId). Server errors (5xx) are logged with error level and slow requests with
+// warning level.
+//
+// To log the final status, errors returned by the next handlers are sent to the mux
+// ErrorHandler by this middleware (see Ctx.HandleError) and not returned. So, use it as
+// one of the first global middlewares, after Recover:
+//
+//	mux.Use(middleware.RecoverDefault())
+//	mux.Use(middleware.AccessLogDefault())
+func AccessLog(opt AccessLogOptions) owl.Middleware {
+	var writerMutex sync.Mutex
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			if isSkippedPath(opt.SkipPaths, ctx.Req.URL.Path) {
+				return next(ctx)
+			}
+			start := time.Now()
+			if err := next(ctx); err != nil {
+				ctx.HandleError(err)
+			}
+			duration := time.Since(start)
+			res := ctx.Response()
+			entry := accessLogEntry{
+				address:  ctx.ClientIP(),
+				user:     sessionUserId(ctx),
+				start:    start,
+				duration: duration,
+				status:   res.Status(),
+				bytes:    res.Size(),
+			}
+
+			args := []any{
+				"method", ctx.Req.Method,
+				"uri", ctx.Req.RequestURI,
+				"route", ctx.RoutePattern(),
+				"status", entry.status,
+				"bytes", entry.bytes,
+				"duration", entry.duration,
+				"address", entry.address,
+				"agent", ctx.Req.UserAgent(),
+				"user", entry.user,
+			}
+			switch {
+			case entry.status >= 500:
+				ctx.Logger.ErrorContext(ctx.Context(), "Access", args...)
+			case opt.SlowThreshold > 0 && duration > opt.SlowThreshold:
+				ctx.Logger.WarnContext(ctx.Context(), "Slow access", args...)
+			default:
+				ctx.Logger.InfoContext(ctx.Context(), "Access", args...)
+			}
+
+			if opt.Writer != nil {
+				writerMutex.Lock()
+				_, err := io.WriteString(opt.Writer, entry.combined(ctx))
+				writerMutex.Unlock()
+				if err != nil {
+					ctx.Logger.ErrorContext(ctx.Context(), "Cannot write access log", "err", err)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+type accessLogEntry struct {
+	address  string
+	user     string
+	start    time.Time
+	duration time.Duration
+	status   int
+	bytes    int64
+}
+
+// combined formats the entry using the Apache combined log format:
+//
+//	127.0.0.1 - 42 [10/Oct/2000:13:55:36 -0700] "GET /index HTTP/1.1" 200 2326 "http://referer" "Mozilla/5.0"
+func (entry accessLogEntry) combined(ctx owl.Ctx) string {
+	size := "-"
+	if entry.bytes > 0 {
+		size = strconv.FormatInt(entry.bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		orDash(entry.address),
+		orDash(entry.user),
+		entry.start.Format(apacheTimeFormat),
+		ctx.Req.Method,
+		escapeLogValue(ctx.Req.RequestURI),
+		ctx.Req.Proto,
+		entry.status,
+		size,
+		orDash(escapeLogValue(ctx.Req.Referer())),
+		orDash(escapeLogValue(ctx.Req.UserAgent())))
+}
+
+func isSkippedPath(skipPaths []string, path string) bool {
+	for _, skip := range skipPaths {
+		if prefix, ok := strings.CutSuffix(skip, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if skip == path {
+			return true
+		}
+	}
+	return false
+}
+
+func sessionUserId(ctx owl.Ctx) string {
+	if user, ok := ctx.Get(session.ContextKey).(session.User); ok {
+		return strconv.FormatInt(user.Id, 10)
+	}
+	return ""
+}
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// escapeLogValue escapes quotes and control characters, so a value cannot break
+// the log line.
+func escapeLogValue(value string) string {
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
@@ -4,19 +4,48 @@ import (
 	"github.com/deltegui/owl"
 )
 
-// Logs HTTP requests using log
+// Logs HTTP requests using log, after the handler is executed, with the status and
+// the size of the response. To log the status sent by the ErrorHandler, errors returned
+// by the next handlers are sent to it by this middleware (see Ctx.HandleError) and not
+// returned.
 func Logger(next owl.Handler) owl.Handler {
 	return func(ctx owl.Ctx) error {
+		if err := next(ctx); err != nil {
+			ctx.HandleError(err)
+		}
+		res := ctx.Response()
+		status := res.Status()
 		ctx.Logger.Info(
 			"Request info",
 			"address",
-			ctx.Req.RemoteAddr,
+			ctx.ClientIP(),
 			"agent",
 			ctx.Req.UserAgent(),
 			"method",
 			ctx.Req.Method,
 			"uri",
-			ctx.Req.RequestURI)
-		return next(ctx)
+			ctx.Req.RequestURI,
+			"status",
+			status,
+			"bytes",
+			res.Size(),
+			"duration",
+			res.Duration())
+		return nil
+	}
+}
+
+// MultipartMaxSize limits the size in bytes of the request body parsed by
+// ctx.ParseForm and ctx.Bind. Bigger bodies are rejected. Use it in a sub mux to
+// configure the limit of a group of routes:
+//
+//	uploads := mux.CreateSubMux("/uploads")
+//	uploads.Use(middleware.MultipartMaxSize(10 << 20))
+func MultipartMaxSize(size int64) owl.Middleware {
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			ctx.SetMultipartMaxSize(size)
+			return next(ctx)
+		}
 	}
 }
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deltegui/owl"
+)
+
+const defaultCompressMinSize = 1024
+
+// defaultCompressSkipTypes are content types that are already compressed.
+var defaultCompressSkipTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+	"application/zstd",
+	"application/pdf",
+	"application/wasm",
+}
+
+type CompressOptions struct {
+	// Level of compression, from flate.BestSpeed to flate.BestCompression.
+	// By default is flate.DefaultCompression.
+	Level int
+
+	// MinSize is the minimum size in bytes of a body to be compressed. By default 1KB.
+	// Flushed responses (like SSE) are always compressed.
+	MinSize int
+
+	// SkipTypes are content type prefixes not compressed, because they are already
+	// compressed. "image/svg+xml" is always compressed. By default skips images, videos,
+	// audios, fonts and compressed files.
+	SkipTypes []string
+}
+
+// CompressDefault is a Compress middleware with default options. See Compress.
+func CompressDefault() owl.Middleware {
+	return Compress(CompressOptions{})
+}
+
+// Compress compresses the responses with gzip or deflate, depending on the request
+// Accept-Encoding header. Responses smaller than MinSize, with a content type in
+// SkipTypes, already encoded or partial (206) are sent as is. Flushing the response
+// flushes the compressor too, so streaming and SSE work.
+func Compress(opt CompressOptions) owl.Middleware {
+	if opt.Level == 0 {
+		opt.Level = flate.DefaultCompression
+	}
+	if opt.MinSize <= 0 {
+		opt.MinSize = defaultCompressMinSize
+	}
+	if opt.SkipTypes == nil {
+		opt.SkipTypes = defaultCompressSkipTypes
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, opt.Level)
+			return w
+		}},
+		"deflate": {New: func() any {
+			w, _ := flate.NewWriter(io.Discard, opt.Level)
+			return w
+		}},
+	}
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			ctx.Res.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(ctx.Req.Header.Get("Accept-Encoding"))
+			if len(encoding) == 0 || ctx.Req.Method == http.MethodHead {
+				return next(ctx)
+			}
+			cw := &compressWriter{
+				ResponseWriter: ctx.Res,
+				opt:            opt,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				status:         ctx.Response().Status(),
+			}
+			defer cw.close()
+			ctx.Res = cw
+			return next(ctx)
+		}
+	}
+}
+
+// compressor is implemented by gzip.Writer and flate.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter buffers the start of the body until MinSize is reached or the
+// response is flushed. Then decides if the response is compressed or not.
+type compressWriter struct {
+	http.ResponseWriter
+	opt      CompressOptions
+	encoding string
+	pool     *sync.Pool
+
+	status        int
+	headerWritten bool
+	decided       bool
+	compressor    compressor
+	buffer        []byte
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.headerWritten {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	cw.headerWritten = true
+	if !cw.canCompress() {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.headerWritten {
+		cw.WriteHeader(cw.status)
+	}
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(data)
+		}
+		return cw.ResponseWriter.Write(data)
+	}
+	if len(cw.Header().Get("Content-Type")) == 0 {
+		cw.Header().Set("Content-Type", http.DetectContentType(append(cw.buffer, data...)))
+	}
+	cw.buffer = append(cw.buffer, data...)
+	if len(cw.buffer) < cw.opt.MinSize {
+		return len(data), nil
+	}
+	if err := cw.decide(cw.canCompress()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// canCompress tells if the response can be compressed looking at its status and headers.
+func (cw *compressWriter) canCompress() bool {
+	header := cw.Header()
+	if cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status == http.StatusPartialContent {
+		return false
+	}
+	if len(header.Get("Content-Encoding")) > 0 || len(header.Get("Content-Range")) > 0 {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, skip := range cw.opt.SkipTypes {
+		if strings.HasPrefix(contentType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide writes the headers and the buffered body, compressed or not.
+func (cw *compressWriter) decide(compress bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	if compress {
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			// The compressed body is not byte to byte equal.
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.compressor = cw.pool.Get().(compressor)
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buffer := cw.buffer
+	cw.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buffer)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffer)
+	}
+	return err
+}
+
+// Flush compresses and sends all the buffered data.
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+func (cw *compressWriter) FlushError() error {
+	if !cw.headerWritten {
+		cw.WriteHeader(cw.status)
+	}
+	if err := cw.decide(cw.canCompress()); err != nil {
+		return err
+	}
+	if cw.compressor != nil {
+		if err := cw.compressor.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends the small bodies without compression and finishes the compressed ones.
+func (cw *compressWriter) close() {
+	if !cw.headerWritten {
+		// Nothing was written. The ErrorHandler will write the response.
+		return
+	}
+	if !cw.decided {
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buffer)))
+		cw.decide(false)
+		return
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.compressor.Reset(io.Discard)
+		cw.pool.Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
+
+// negotiateEncoding returns "gzip" or "deflate" depending on the Accept-Encoding
+// header, or empty if none is accepted. gzip is preferred when both have the same quality.
+func negotiateEncoding(acceptEncoding string) string {
+	best := ""
+	bestQ := 0.0
+	wildcard := -1.0
+	qualities := map[string]float64{}
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if qValue, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(qValue, 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qualities[coding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
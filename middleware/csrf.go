@@ -13,8 +13,7 @@ func Csrf(cs *csrf.Csrf) owl.Middleware {
 		return func(ctx owl.Ctx) error {
 			if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodOptions {
 				if err := cs.CheckRequest(ctx.Req); err != nil {
-					ctx.Res.WriteHeader(http.StatusForbidden)
-					return fmt.Errorf("invalid csrf: %w", err)
+					return owl.NewHttpError(http.StatusForbidden, fmt.Errorf("invalid csrf: %w", err))
 				}
 			}
 
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/deltegui/owl"
+)
+
+const defaultETagMaxSize = 1 << 20
+
+type ETagOptions struct {
+	// MaxSize is the maximum size in bytes of a buffered response. Bigger responses
+	// are sent without ETag. By default 1MB.
+	MaxSize int
+}
+
+// ETagDefault is an ETag middleware with default options. See ETag.
+func ETagDefault() owl.Middleware {
+	return ETag(ETagOptions{})
+}
+
+// ETag buffers the ok (200) responses of GET and HEAD requests and computes a weak
+// ETag of the body, unless the handler sets its own. If the client already has the
+// response (If-None-Match or If-Modified-Since headers), the body is discarded and not
+// modified (304) is sent. Flushed responses (like SSE) are sent without buffering.
+//
+// The handler still runs, so use ctx.CheckETag and ctx.NotModifiedSince to skip the
+// work when you know the version of the resource before rendering. Use it after
+// Compress, so the ETag is computed over the uncompressed body:
+//
+//	mux.Use(middleware.CompressDefault())
+//	mux.Use(middleware.ETagDefault())
+func ETag(opt ETagOptions) owl.Middleware {
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = defaultETagMaxSize
+	}
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+				return next(ctx)
+			}
+			res := ctx.Res
+			ew := &etagWriter{
+				ResponseWriter: res,
+				maxSize:        opt.MaxSize,
+				status:         ctx.Response().Status(),
+			}
+			ctx.Res = ew
+			if err := next(ctx); err != nil {
+				if ew.headerWritten {
+					ew.passthrough()
+				}
+				return err
+			}
+			if !ew.headerWritten || ew.streaming {
+				return nil
+			}
+
+			ctx.Res = res
+			header := res.Header()
+			tag := header.Get("ETag")
+			if len(tag) == 0 {
+				tag = weakETag(ew.buffer.Bytes())
+			}
+			if ctx.CheckETag(tag) {
+				return nil
+			}
+			if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && ctx.NotModifiedSince(modified) {
+				return nil
+			}
+			header.Set("Content-Length", strconv.Itoa(ew.buffer.Len()))
+			res.WriteHeader(ew.status)
+			_, err := res.Write(ew.buffer.Bytes())
+			return err
+		}
+	}
+}
+
+// weakETag creates an ETag with the length and the FNV-1a hash of body.
+func weakETag(body []byte) string {
+	hash := fnv.New64a()
+	hash.Write(body)
+	return fmt.Sprintf(`W/"%x-%x"`, len(body), hash.Sum64())
+}
+
+// etagWriter buffers ok (200) responses. Other statuses, big bodies and flushes
+// make it send the buffered data and write the rest directly.
+type etagWriter struct {
+	http.ResponseWriter
+	maxSize int
+
+	status        int
+	headerWritten bool
+	streaming     bool
+	buffer        bytes.Buffer
+}
+
+func (ew *etagWriter) WriteHeader(status int) {
+	if ew.streaming {
+		ew.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if ew.headerWritten {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		ew.ResponseWriter.WriteHeader(status)
+		return
+	}
+	ew.status = status
+	ew.headerWritten = true
+	if status != http.StatusOK {
+		ew.passthrough()
+	}
+}
+
+func (ew *etagWriter) Write(data []byte) (int, error) {
+	if !ew.headerWritten {
+		ew.WriteHeader(ew.status)
+	}
+	if !ew.streaming && ew.buffer.Len()+len(data) > ew.maxSize {
+		if err := ew.passthrough(); err != nil {
+			return 0, err
+		}
+	}
+	if ew.streaming {
+		return ew.ResponseWriter.Write(data)
+	}
+	return ew.buffer.Write(data)
+}
+
+// passthrough sends the status and the buffered body. Next writes are not buffered.
+func (ew *etagWriter) passthrough() error {
+	if ew.streaming {
+		return nil
+	}
+	ew.streaming = true
+	ew.ResponseWriter.WriteHeader(ew.status)
+	if ew.buffer.Len() == 0 {
+		return nil
+	}
+	_, err := ew.ResponseWriter.Write(ew.buffer.Bytes())
+	ew.buffer.Reset()
+	return err
+}
+
+func (ew *etagWriter) Flush() {
+	ew.FlushError()
+}
+
+func (ew *etagWriter) FlushError() error {
+	if !ew.headerWritten {
+		ew.WriteHeader(ew.status)
+	}
+	if err := ew.passthrough(); err != nil {
+		return err
+	}
+	return http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+func (ew *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(ew.ResponseWriter).Hijack()
+}
+
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
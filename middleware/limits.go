@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/deltegui/owl"
+)
+
+// BodyLimit limits the size in bytes of the request body read by the next handlers,
+// for example with ctx.ParseJson or ctx.Bind. Requests with a bigger Content-Length
+// are rejected before calling the handler. If the handler reads more than size bytes
+// and returns the read error, its replaced with a request entity too large (413)
+// owl.HttpError, so the ErrorHandler sends that status. Use it per route or sub mux:
+//
+//	api := mux.CreateSubMux("/api")
+//	api.Use(middleware.BodyLimit(1 << 20))
+//	api.Post("/import", importHandler, middleware.BodyLimit(50<<20))
+//
+// The inner limit is applied to the body already limited by the outer one, so the
+// smallest limit wins. Use MultipartMaxSize for form uploads.
+func BodyLimit(size int64) owl.Middleware {
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			if ctx.Req.ContentLength > size {
+				ctx.Logger.WarnContext(ctx.Context(), "Request body too large", "limit", size, "length", ctx.Req.ContentLength, "uri", ctx.Req.RequestURI)
+				return owl.NewHttpError(http.StatusRequestEntityTooLarge, fmt.Errorf("body of %d bytes exceeds the limit of %d bytes", ctx.Req.ContentLength, size))
+			}
+			if ctx.Req.Body != nil {
+				ctx.Req.Body = http.MaxBytesReader(ctx.Res, ctx.Req.Body, size)
+			}
+			err := next(ctx)
+			var maxErr *http.MaxBytesError
+			if err != nil && errors.As(err, &maxErr) {
+				ctx.Logger.WarnContext(ctx.Context(), "Request body too large", "limit", size, "uri", ctx.Req.RequestURI)
+				return owl.NewHttpError(http.StatusRequestEntityTooLarge, err)
+			}
+			return err
+		}
+	}
+}
+
+// Timeout puts a deadline on the request context. Handlers must pass ctx.Context()
+// (or ctx.Req.Context()) to slow operations, like database queries, so they are
+// cancelled when the time is over. If the deadline is exceeded and the handler
+// returns an error, its replaced with a service unavailable (503) owl.HttpError,
+// so the ErrorHandler sends that status. Use it per route or sub mux:
+//
+//	mux.Get("/report", showReport, middleware.Timeout(5*time.Second))
+//
+// The timeout is cooperative only: the handler is not interrupted and the response
+// is not cut when the deadline is exceeded. A handler that ignores the context keeps
+// running and can still write its response. When the handler returns, the deadline is
+// removed, but the values set with ctx.Set by the next handlers are kept.
+//
+// Don't use it for long lived responses, like SSE streams or WebSockets.
+func Timeout(timeout time.Duration) owl.Middleware {
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			prev := ctx.Context()
+			timeoutCtx, cancel := context.WithTimeout(prev, timeout)
+			defer func() {
+				cancel()
+				ctx.SetContext(valuesContext{
+					Context: prev,
+					values:  context.WithoutCancel(ctx.Context()),
+				})
+			}()
+			ctx.SetContext(timeoutCtx)
+			ctx.Req = ctx.Req.WithContext(timeoutCtx)
+			err := next(ctx)
+			if err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+				ctx.Logger.WarnContext(ctx.Context(), "Request timed out", "timeout", timeout, "uri", ctx.Req.RequestURI)
+				return owl.NewHttpError(http.StatusServiceUnavailable, fmt.Errorf("request timed out after %s: %w", timeout, err))
+			}
+			return err
+		}
+	}
+}
+
+// valuesContext has the values of a context and the deadline and cancellation of
+// other. Used to remove a deadline without losing the values set after it.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	return c.values.Value(key)
+}
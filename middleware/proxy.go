@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/deltegui/owl"
+)
+
+// PrivateNetworks are the loopback and private networks. Use them as trusted proxies
+// when your reverse proxy runs in the same host or private network.
+var PrivateNetworks = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+type TrustedProxiesOptions struct {
+	// Proxies are the IPs or CIDRs of your reverse proxies. For example:
+	// []string{"10.0.0.0/8", "203.0.113.7"}. See PrivateNetworks.
+	Proxies []string
+}
+
+// forwardedHop is a proxy hop described by the Forwarded or X-Forwarded-* headers.
+type forwardedHop struct {
+	client string
+	scheme string
+	host   string
+}
+
+// apply sets the valid scheme and host of the hop.
+func (hop forwardedHop) apply(forwarded *owl.Forwarded) {
+	if scheme := strings.ToLower(hop.scheme); scheme == "http" || scheme == "https" {
+		forwarded.Scheme = scheme
+	}
+	if isValidForwardedHost(hop.host) {
+		forwarded.Host = hop.host
+	}
+}
+
+// TrustedProxies resolves the real client IP, scheme and host of requests that come
+// from a trusted proxy. It reads the RFC 7239 Forwarded header or, if the request does
+// not have it, the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers.
+// Requests from other addresses are not trusted and their headers are ignored.
+//
+// The client IP is the first address, from right to left, that is not a trusted proxy.
+// The values are stored in the context with owl.ForwardedKey, so ctx.ClientIP(),
+// ctx.Scheme() and ctx.Host() return them. Redirects, cookies, logs and rate limits
+// use them too. Use it as the first global middleware:
+//
+//	mux.Use(middleware.TrustedProxies(middleware.TrustedProxiesOptions{
+//		Proxies: middleware.PrivateNetworks,
+//	}))
+func TrustedProxies(opt TrustedProxiesOptions) owl.Middleware {
+	prefixes := make([]netip.Prefix, 0, len(opt.Proxies))
+	for _, proxy := range opt.Proxies {
+		prefix, err := parseProxy(proxy)
+		if err != nil {
+			log.Panicf("invalid trusted proxy '%s': %s", proxy, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	isTrusted := func(ip netip.Addr) bool {
+		ip = ip.Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			remote, err := netip.ParseAddr(remoteHost(ctx.Req.RemoteAddr))
+			if err != nil || !isTrusted(remote) {
+				return next(ctx)
+			}
+			forwarded := owl.Forwarded{ClientIP: remote.Unmap().String()}
+			hops, ok := parseForwardedHeader(ctx.Req.Header.Values("Forwarded"))
+			if !ok {
+				hops = parseXForwardedFor(ctx.Req.Header.Values("X-Forwarded-For"))
+				// X-Forwarded-Proto and X-Forwarded-Host are set by the nearest proxy.
+				forwardedHop{
+					scheme: lastHeaderValue(ctx.Req.Header.Values("X-Forwarded-Proto")),
+					host:   lastHeaderValue(ctx.Req.Header.Values("X-Forwarded-Host")),
+				}.apply(&forwarded)
+			}
+			for _, hop := range hops {
+				ip, err := netip.ParseAddr(hop.client)
+				if err != nil {
+					break
+				}
+				forwarded.ClientIP = ip.Unmap().String()
+				hop.apply(&forwarded)
+				if !isTrusted(ip) {
+					break
+				}
+			}
+			ctx.Set(owl.ForwardedKey, forwarded)
+			// Also in the request, for the code that only receives it, like session cookies.
+			ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), owl.ForwardedKey, forwarded))
+			return next(ctx)
+		}
+	}
+}
+
+func remoteHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	ip, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// parseForwardedHeader parses the RFC 7239 Forwarded header, returning the hops from the
+// nearest proxy to the client. For example:
+//
+//	Forwarded: for=192.0.2.60;proto=https;host=example.com, for="[2001:db8::1]:4711"
+func parseForwardedHeader(values []string) ([]forwardedHop, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	var hops []forwardedHop
+	for _, value := range values {
+		for element := range strings.SplitSeq(value, ",") {
+			hop := forwardedHop{}
+			for pair := range strings.SplitSeq(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop.client = forwardedNodeIP(value)
+				case "proto":
+					hop.scheme = value
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	slices.Reverse(hops)
+	return hops, true
+}
+
+// forwardedNodeIP removes the port and brackets of a Forwarded node, like
+// "[2001:db8::1]:4711" or "192.0.2.60:8080".
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		ip, _, _ := strings.Cut(strings.TrimPrefix(node, "["), "]")
+		return ip
+	}
+	if strings.Count(node, ":") == 1 {
+		ip, _, _ := strings.Cut(node, ":")
+		return ip
+	}
+	return node
+}
+
+// parseXForwardedFor parses the X-Forwarded-For header, returning the hops from the
+// nearest proxy to the client.
+func parseXForwardedFor(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range values {
+		for client := range strings.SplitSeq(value, ",") {
+			hops = append(hops, forwardedHop{client: strings.TrimSpace(client)})
+		}
+	}
+	slices.Reverse(hops)
+	return hops
+}
+
+func lastHeaderValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	parts := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// isValidForwardedHost only accepts hosts, with an optional port, without paths or spaces,
+// so they are safe to use in absolute URLs.
+func isValidForwardedHost(host string) bool {
+	if len(host) == 0 || len(host) > 255 {
+		return false
+	}
+	for _, c := range host {
+		isAlphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphanumeric && c != '-' && c != '.' && c != ':' && c != '[' && c != ']' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/deltegui/owl"
+	"github.com/deltegui/owl/core"
+	"github.com/deltegui/owl/session"
+)
+
+// ErrTooManyRequests is returned by RateLimit when a client exceeds the limit. Its code
+// is 429, so the ErrorHandler responds with status too many requests (429) and you can
+// localize the message adding a translation with the key "429" to your errors file.
+var ErrTooManyRequests = core.DomainError{
+	Code:    http.StatusTooManyRequests,
+	Message: "Too many requests",
+}
+
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket lets clients make bursts of Limit requests. The bucket
+	// is refilled at a rate of Limit requests per Window.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow allows Limit requests in any period of Window. The
+	// count of the previous window is weighted, so the limit is smooth.
+	SlidingWindow
+)
+
+// rateLimitGarbageInterval is the minimum time between two evictions of expired
+// entries of MemoryRateLimitStore.
+const rateLimitGarbageInterval = time.Minute
+
+// RateLimitEntry is the state of a rate limit key saved in a RateLimitStore.
+type RateLimitEntry struct {
+	// Tokens left in the bucket (TokenBucket).
+	Tokens float64
+	// Count of requests in the current window and in the previous one (SlidingWindow).
+	Count     int
+	PrevCount int
+	// Last is the last refill time (TokenBucket) or the current window start (SlidingWindow).
+	Last time.Time
+	// Expires is the time when the entry can be deleted.
+	Expires time.Time
+}
+
+// RateLimitStore saves the state of the rate limit keys. Implement it to share the
+// limits between many instances of your server.
+type RateLimitStore interface {
+	// Update loads the entry of key, calls update with it and saves the returned
+	// entry. It must be atomic: requests with the same key cannot update the entry
+	// at the same time. found is false if there is no entry or it has expired.
+	Update(key string, update func(entry RateLimitEntry, found bool) RateLimitEntry)
+	RecollectGarbage()
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps the entries in memory. Expired
+// entries are evicted periodically while updating.
+type MemoryRateLimitStore struct {
+	values    map[string]RateLimitEntry
+	mutex     sync.Mutex
+	nextSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		values: make(map[string]RateLimitEntry),
+	}
+}
+
+func (store *MemoryRateLimitStore) Update(key string, update func(entry RateLimitEntry, found bool) RateLimitEntry) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	if now.After(store.nextSweep) {
+		store.evict(now)
+		store.nextSweep = now.Add(rateLimitGarbageInterval)
+	}
+	entry, found := store.values[key]
+	if found && now.After(entry.Expires) {
+		found = false
+	}
+	store.values[key] = update(entry, found)
+}
+
+func (store *MemoryRateLimitStore) RecollectGarbage() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.evict(time.Now())
+}
+
+func (store *MemoryRateLimitStore) evict(now time.Time) {
+	for key, entry := range store.values {
+		if now.After(entry.Expires) {
+			delete(store.values, key)
+		}
+	}
+}
+
+type RateLimitOptions struct {
+	Algorithm RateLimitAlgorithm
+
+	// Limit is the number of requests allowed for each key in a Window.
+	Limit  int
+	Window time.Duration
+
+	// Key identifies the client. By default is KeyByIP. See KeyByUser.
+	Key func(ctx owl.Ctx) string
+
+	// Store of the rate limit entries. By default a new MemoryRateLimitStore.
+	Store RateLimitStore
+}
+
+// KeyByIP uses the client IP as rate limit key. See Ctx.ClientIP.
+func KeyByIP(ctx owl.Ctx) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// KeyByUser uses the logged user id as rate limit key (see Authorize). Anonymous requests
+// use the client IP.
+func KeyByUser(ctx owl.Ctx) string {
+	if user, ok := ctx.Get(session.ContextKey).(session.User); ok {
+		return "user:" + strconv.FormatInt(user.Id, 10)
+	}
+	return KeyByIP(ctx)
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// RateLimit limits the number of requests of each client. The responses have the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers.
+// When the limit is exceeded, ErrTooManyRequests is returned and the Retry-After header
+// is set. For example, to allow 5 login attempts per minute:
+//
+//	mux.Post("/login", login, middleware.RateLimit(middleware.RateLimitOptions{
+//		Algorithm: middleware.SlidingWindow,
+//		Limit:     5,
+//		Window:    time.Minute,
+//	}))
+func RateLimit(opt RateLimitOptions) owl.Middleware {
+	if opt.Limit <= 0 || opt.Window <= 0 {
+		log.Panicln("RateLimit needs a positive Limit and Window")
+	}
+	if opt.Key == nil {
+		opt.Key = KeyByIP
+	}
+	if opt.Store == nil {
+		opt.Store = NewMemoryRateLimitStore()
+	}
+	policy := fmt.Sprintf("%d;w=%d", opt.Limit, int(math.Ceil(opt.Window.Seconds())))
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			key := opt.Key(ctx)
+			var result rateLimitResult
+			opt.Store.Update(key, func(entry RateLimitEntry, found bool) RateLimitEntry {
+				if opt.Algorithm == SlidingWindow {
+					entry, result = slidingWindow(entry, found, opt.Limit, opt.Window, time.Now())
+				} else {
+					entry, result = tokenBucket(entry, found, opt.Limit, opt.Window, time.Now())
+				}
+				return entry
+			})
+
+			header := ctx.Res.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(opt.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+			header.Set("RateLimit-Policy", policy)
+			if !result.allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+				return ErrTooManyRequests.Wrapf("rate limit exceeded for %s", key)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+func tokenBucket(entry RateLimitEntry, found bool, limit int, window time.Duration, now time.Time) (RateLimitEntry, rateLimitResult) {
+	capacity := float64(limit)
+	rate := capacity / window.Seconds()
+	if !found {
+		entry = RateLimitEntry{Tokens: capacity, Last: now}
+	}
+	entry.Tokens = min(capacity, entry.Tokens+now.Sub(entry.Last).Seconds()*rate)
+	entry.Last = now
+
+	result := rateLimitResult{}
+	if entry.Tokens >= 1 {
+		entry.Tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = secondsToDuration((1 - entry.Tokens) / rate)
+	}
+	result.remaining = int(entry.Tokens)
+	result.reset = secondsToDuration((capacity - entry.Tokens) / rate)
+	entry.Expires = now.Add(result.reset)
+	return entry, result
+}
+
+func slidingWindow(entry RateLimitEntry, found bool, limit int, window time.Duration, now time.Time) (RateLimitEntry, rateLimitResult) {
+	start := now.Truncate(window)
+	switch {
+	case !found || entry.Last.Before(start.Add(-window)):
+		entry = RateLimitEntry{Last: start}
+	case entry.Last.Before(start):
+		entry = RateLimitEntry{PrevCount: entry.Count, Last: start}
+	}
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(entry.PrevCount)*weight + float64(entry.Count)
+
+	result := rateLimitResult{reset: window - elapsed}
+	if estimated+1 <= float64(limit) {
+		entry.Count++
+		estimated++
+		result.allowed = true
+	} else {
+		result.retryAfter = result.reset
+	}
+	result.remaining = max(0, limit-int(math.Ceil(estimated)))
+	entry.Expires = start.Add(2 * window)
+	return entry, result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/deltegui/owl"
+)
+
+type RecoverOptions struct {
+	// Development renders a detailed page with the panic, the stack trace,
+	// the request headers, the URL params and the context values. Never
+	// enable it in production. If its false, the panic is sent to the
+	// mux ErrorHandler as an internal server error (500).
+	Development bool
+}
+
+// RecoverDefault is a Recover middleware with production options. See Recover.
+func RecoverDefault() owl.Middleware {
+	return Recover(RecoverOptions{})
+}
+
+// Recover catches any panic produced by the next handlers and logs it with its stack
+// trace. You should use it as the first global middleware:
+//
+//	mux.Use(middleware.Recover(middleware.RecoverOptions{Development: conf.Debug}))
+func Recover(opt RecoverOptions) owl.Middleware {
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) (err error) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				stack := debug.Stack()
+				ctx.Logger.ErrorContext(
+					ctx.Context(),
+					"Recovered from panic",
+					"panic",
+					recovered,
+					"method",
+					ctx.Req.Method,
+					"uri",
+					ctx.Req.RequestURI,
+					"stack",
+					string(stack))
+
+				if opt.Development && !ctx.Written() {
+					err = renderPanicPage(ctx, recovered, stack)
+					return
+				}
+				err = owl.NewHttpError(http.StatusInternalServerError, fmt.Errorf("recovered from panic: %v", recovered))
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+type panicPage struct {
+	Panic   string
+	Stack   string
+	Method  string
+	URL     string
+	Headers http.Header
+	Params  map[string]string
+	Values  map[string]string
+	Nonce   string
+}
+
+func renderPanicPage(ctx owl.Ctx, recovered any, stack []byte) error {
+	values := map[string]string{}
+	for k, v := range ctx.Values() {
+		values[fmt.Sprint(k)] = fmt.Sprintf("%+v", v)
+	}
+	page := panicPage{
+		Panic:   fmt.Sprint(recovered),
+		Stack:   string(stack),
+		Method:  ctx.Req.Method,
+		URL:     ctx.Req.URL.String(),
+		Headers: ctx.Req.Header,
+		Params:  ctx.GetURLParams(),
+		Values:  values,
+		Nonce:   ctx.CspNonce(),
+	}
+	ctx.Res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Status(http.StatusInternalServerError)
+	return panicTemplate.Execute(ctx.Res, page)
+}
+
+var panicTemplate = template.Must(template.New("owl_panic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Panic: {{ .Panic }}</title>
+	<style{{ if .Nonce }} nonce="{{ .Nonce }}"{{ end }}>
+		body { font-family: sans-serif; margin: 2em; }
+		pre { background: #f4f4f4; padding: 1em; overflow: auto; }
+		table { border-collapse: collapse; }
+		td { border: 1px solid #ddd; padding: 0.3em 0.6em; vertical-align: top; }
+	</style>
+</head>
+<body>
+	<h1>Panic: {{ .Panic }}</h1>
+	<p>{{ .Method }} {{ .URL }}</p>
+	<h2>Stack trace</h2>
+	<pre>{{ .Stack }}</pre>
+	<h2>Request headers</h2>
+	<table>
+	{{ range $key, $values := .Headers }}<tr><td>{{ $key }}</td><td>{{ range $values }}{{ . }} {{ end }}</td></tr>{{ end }}
+	</table>
+	<h2>URL params</h2>
+	<table>
+	{{ range $key, $value := .Params }}<tr><td>{{ $key }}</td><td>{{ $value }}</td></tr>{{ end }}
+	</table>
+	<h2>Context values</h2>
+	<table>
+	{{ range $key, $value := .Values }}<tr><td>{{ $key }}</td><td>{{ $value }}</td></tr>{{ end }}
+	</table>
+</body>
+</html>
+`))
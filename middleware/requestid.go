@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"github.com/deltegui/owl"
+	"github.com/deltegui/owl/core"
+	"github.com/deltegui/owl/logx"
+)
+
+const (
+	RequestIdHeader    = "X-Request-Id"
+	maxRequestIdLength = 128
+)
+
+type RequestIdOptions struct {
+	// Header used to read and send the request id. By default is X-Request-Id.
+	Header string
+
+	// Generator creates the id of requests without a valid one. By default
+	// creates a random token. Use core.GenerateSortableId for sortable ids.
+	Generator func() string
+}
+
+// RequestIdDefault is a RequestId middleware with default options. See RequestId.
+func RequestIdDefault() owl.Middleware {
+	return RequestId(RequestIdOptions{})
+}
+
+// RequestId reads the request id from the request header or, if there is no valid
+// one, creates a new id. The id is sent back in the same response header and stored
+// in the context with logx.RequestIdKey, so:
+//
+//   - ctx.RequestId() returns it.
+//   - Views can use it with ViewModel.RequestId.
+//   - The ctx.Logger *Context methods add it to the logs when they receive ctx.Context().
+//
+// Use it as the first global middleware, so all the logs have the id.
+func RequestId(opt RequestIdOptions) owl.Middleware {
+	if len(opt.Header) == 0 {
+		opt.Header = RequestIdHeader
+	}
+	if opt.Generator == nil {
+		opt.Generator = generateRequestId
+	}
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			id := ctx.Req.Header.Get(opt.Header)
+			if !isValidRequestId(id) {
+				id = opt.Generator()
+			}
+			ctx.Set(logx.RequestIdKey, id)
+			ctx.Res.Header().Set(opt.Header, id)
+			return next(ctx)
+		}
+	}
+}
+
+func generateRequestId() string {
+	return core.GenerateToken(core.Size16)
+}
+
+// isValidRequestId only accepts ids with a reasonable length and safe characters,
+// so a client cannot inject content into the logs.
+func isValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		isAlphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphanumeric && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/deltegui/owl"
+	"github.com/deltegui/owl/core"
+)
+
+// Common Content-Security-Policy sources.
+const (
+	CspSelf          string = "'self'"
+	CspNone          string = "'none'"
+	CspStrictDynamic string = "'strict-dynamic'"
+	CspData          string = "data:"
+
+	// CspNonce is replaced with the nonce of each request, like 'nonce-Rj3c9...'.
+	CspNonce string = "'nonce'"
+)
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// CspPolicy builds a Content-Security-Policy header. Start from NewCspPolicy or
+// DefaultCspPolicy and add what you need:
+//
+//	policy := middleware.DefaultCspPolicy().
+//		Add("img-src", "https://cdn.example.com").
+//		Add("connect-src", "wss://example.com")
+type CspPolicy struct {
+	directives []cspDirective
+}
+
+// NewCspPolicy creates an empty policy.
+func NewCspPolicy() *CspPolicy {
+	return &CspPolicy{}
+}
+
+// DefaultCspPolicy creates a strict policy. Only resources from the same origin are
+// allowed and inline scripts and styles need the request nonce (see Ctx.CspNonce):
+//
+//	default-src 'self'; script-src 'self' 'nonce-...'; style-src 'self' 'nonce-...';
+//	img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self';
+//	frame-ancestors 'none'
+func DefaultCspPolicy() *CspPolicy {
+	return NewCspPolicy().
+		Add("default-src", CspSelf).
+		Add("script-src", CspSelf, CspNonce).
+		Add("style-src", CspSelf, CspNonce).
+		Add("img-src", CspSelf, CspData).
+		Add("object-src", CspNone).
+		Add("base-uri", CspSelf).
+		Add("form-action", CspSelf).
+		Add("frame-ancestors", CspNone)
+}
+
+// Add appends sources to a directive, creating it if it does not exist. Directives
+// without sources, like "upgrade-insecure-requests", are added without arguments.
+func (policy *CspPolicy) Add(directive string, sources ...string) *CspPolicy {
+	directive = strings.ToLower(directive)
+	for i := range policy.directives {
+		if policy.directives[i].name == directive {
+			for _, source := range sources {
+				if !slices.Contains(policy.directives[i].sources, source) {
+					policy.directives[i].sources = append(policy.directives[i].sources, source)
+				}
+			}
+			return policy
+		}
+	}
+	policy.directives = append(policy.directives, cspDirective{
+		name:    directive,
+		sources: slices.Clone(sources),
+	})
+	return policy
+}
+
+// Set replaces the sources of a directive.
+func (policy *CspPolicy) Set(directive string, sources ...string) *CspPolicy {
+	return policy.Remove(directive).Add(directive, sources...)
+}
+
+// Remove deletes a directive.
+func (policy *CspPolicy) Remove(directive string) *CspPolicy {
+	directive = strings.ToLower(directive)
+	policy.directives = slices.DeleteFunc(policy.directives, func(d cspDirective) bool {
+		return d.name == directive
+	})
+	return policy
+}
+
+// String returns the header value, with the CspNonce placeholder if the policy uses it.
+func (policy *CspPolicy) String() string {
+	parts := make([]string, 0, len(policy.directives))
+	for _, directive := range policy.directives {
+		parts = append(parts, strings.Join(append([]string{directive.name}, directive.sources...), " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+type SecureHeadersOptions struct {
+	// ContentSecurityPolicy is sent in the Content-Security-Policy header. If its nil
+	// the header is not sent.
+	ContentSecurityPolicy *CspPolicy
+
+	// CspReportOnly sends the policy in the Content-Security-Policy-Report-Only header,
+	// so the browser reports violations without blocking anything. Useful to test a
+	// new policy.
+	CspReportOnly bool
+
+	// The next fields are the values of their headers. Empty values are not sent.
+	FrameOptions              string
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	ContentTypeOptions        string
+	CrossOriginOpenerPolicy   string
+	CrossOriginResourcePolicy string
+
+	// StrictTransportSecurity is not sent by default. Only enable it if your site
+	// is always served with HTTPS. For example: "max-age=63072000; includeSubDomains"
+	StrictTransportSecurity string
+}
+
+// DefaultSecureHeadersOptions returns the options used by SecureHeadersDefault.
+// Start from them and change what you need.
+func DefaultSecureHeadersOptions() SecureHeadersOptions {
+	return SecureHeadersOptions{
+		ContentSecurityPolicy:     DefaultCspPolicy(),
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+		ContentTypeOptions:        "nosniff",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+// SecureHeadersDefault is a SecureHeaders middleware with default options.
+// See DefaultSecureHeadersOptions.
+func SecureHeadersDefault() owl.Middleware {
+	return SecureHeaders(DefaultSecureHeadersOptions())
+}
+
+// SecureHeaders sets security headers to all responses. If the policy uses CspNonce,
+// a new nonce is created for each request and stored in the context with
+// owl.CspNonceKey, so:
+//
+//   - ctx.CspNonce() returns it.
+//   - Views can use it with ViewModel.CspNonce, ViewModel.PlaceCspNonce or the
+//     CspNonce template function: <script {{ CspNonce . }}>...</script>
+//
+// This way inline scripts work with a strict policy, without 'unsafe-inline'.
+func SecureHeaders(opt SecureHeadersOptions) owl.Middleware {
+	policy := ""
+	if opt.ContentSecurityPolicy != nil {
+		policy = opt.ContentSecurityPolicy.String()
+	}
+	usesNonce := strings.Contains(policy, CspNonce)
+	policyHeader := "Content-Security-Policy"
+	if opt.CspReportOnly {
+		policyHeader = "Content-Security-Policy-Report-Only"
+	}
+	headers := map[string]string{
+		"X-Frame-Options":              opt.FrameOptions,
+		"Referrer-Policy":              opt.ReferrerPolicy,
+		"Permissions-Policy":           opt.PermissionsPolicy,
+		"X-Content-Type-Options":       opt.ContentTypeOptions,
+		"Cross-Origin-Opener-Policy":   opt.CrossOriginOpenerPolicy,
+		"Cross-Origin-Resource-Policy": opt.CrossOriginResourcePolicy,
+		"Strict-Transport-Security":    opt.StrictTransportSecurity,
+	}
+	return func(next owl.Handler) owl.Handler {
+		return func(ctx owl.Ctx) error {
+			header := ctx.Res.Header()
+			for name, value := range headers {
+				if len(value) > 0 {
+					header.Set(name, value)
+				}
+			}
+			if len(policy) > 0 {
+				value := policy
+				if usesNonce {
+					nonce := core.GenerateToken(core.Size16)
+					ctx.Set(owl.CspNonceKey, nonce)
+					value = strings.ReplaceAll(policy, CspNonce, "'nonce-"+nonce+"'")
+				}
+				header.Set(policyHeader, value)
+			}
+			return next(ctx)
+		}
+	}
+}
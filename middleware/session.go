@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 
 	"slices"
@@ -10,13 +11,19 @@ import (
 	"github.com/deltegui/owl/session"
 )
 
+// ErrNotAllowedRole is returned when the logged user
+// does not have any of the required roles.
+var ErrNotAllowedRole = errors.New("user does not have a required role")
+
+// Authorize only lets pass requests with a valid session. If the session is not valid
+// and url is not empty, the request is redirected to url. Otherwise an owl.HttpError
+// with status unauthorized (401) is returned.
 func Authorize(manager *session.Manager, url string) owl.Middleware {
 	return func(next owl.Handler) owl.Handler {
 		return func(ctx owl.Ctx) error {
 			user, err := manager.ReadSessionCookie(ctx.Req)
 			if err != nil {
-				handleError(ctx, url)
-				return err
+				return handleError(ctx, url, http.StatusUnauthorized, err)
 			}
 			ctx.Set(session.ContextKey, user)
 			return next(ctx)
@@ -24,38 +31,37 @@ func Authorize(manager *session.Manager, url string) owl.Middleware {
 	}
 }
 
+// AuthorizeRoles works like Authorize, but the user must have one of the roles. If
+// the user is logged but does not have any role, an owl.HttpError with status
+// forbidden (403) is returned.
 func AuthorizeRoles(manager *session.Manager, url string, roles []core.Role) owl.Middleware {
 	return func(next owl.Handler) owl.Handler {
 		return func(ctx owl.Ctx) error {
 			user, err := manager.ReadSessionCookie(ctx.Req)
 			if err != nil {
-				handleError(ctx, url)
-				return err
+				return handleError(ctx, url, http.StatusUnauthorized, err)
 			}
 			for _, userRole := range user.Roles {
 				if slices.Contains(roles, userRole) {
+					ctx.Set(session.ContextKey, user)
 					return next(ctx)
 				}
 			}
-			handleError(ctx, url)
-			return nil
+			return handleError(ctx, url, http.StatusForbidden, ErrNotAllowedRole)
 		}
 	}
 }
 
+// Admin works like AuthorizeRoles with core.RoleAdmin.
 func Admin(manager *session.Manager, url string) owl.Middleware {
 	return func(next owl.Handler) owl.Handler {
 		return func(ctx owl.Ctx) error {
 			user, err := manager.ReadSessionCookie(ctx.Req)
 			if err != nil {
-				ctx.Logger.ErrorContext(ctx.Context(), "Error while authenticating:", err)
-				handleError(ctx, url)
-				return err
+				return handleError(ctx, url, http.StatusUnauthorized, err)
 			}
 			if !slices.Contains(user.Roles, core.RoleAdmin) {
-				ctx.Logger.ErrorContext(ctx.Context(), "User is not admin!")
-				handleError(ctx, url)
-				return err
+				return handleError(ctx, url, http.StatusForbidden, ErrNotAllowedRole)
 			}
 			ctx.Set(session.ContextKey, user)
 			return next(ctx)
@@ -63,12 +69,10 @@ func Admin(manager *session.Manager, url string) owl.Middleware {
 	}
 }
 
-func handleError(ctx owl.Ctx, url string) {
+func handleError(ctx owl.Ctx, url string, status int, err error) error {
 	if len(url) > 0 {
-		http.Redirect(ctx.Res, ctx.Req, url, http.StatusTemporaryRedirect)
-		ctx.Logger.ErrorContext(ctx.Context(), "Authentication failed. Redirecting to url: %s", url)
-	} else {
-		ctx.Res.WriteHeader(http.StatusUnauthorized)
-		ctx.Logger.ErrorContext(ctx.Context(), "Authentication failed")
+		ctx.Logger.WarnContext(ctx.Context(), "Authentication failed. Redirecting", "url", url, "err", err)
+		return ctx.Redirect(url)
 	}
+	return owl.NewHttpError(status, err)
 }
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/deltegui/owl"
+	"github.com/deltegui/owl/session"
+)
+
+// WebSocketOrigin returns an origin check for owl.WebSocketOptions that uses the
+// AllowOrigin of CorsOptions. The same origin (the Origin host is the request host) is
+// always allowed, and other origins only if AllowOrigin is exactly that origin. Unlike
+// the Cors middleware, an empty AllowOrigin or CorsAny does not allow any origin, so
+// other websites cannot open sockets with the cookies of your users. Requests without
+// Origin header (non browser clients) are allowed.
+func WebSocketOrigin(opt CorsOptions) func(req *http.Request) bool {
+	return func(req *http.Request) bool {
+		origin := req.Header.Get("Origin")
+		if len(origin) == 0 {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, req.Host) {
+			return true
+		}
+		return len(opt.AllowOrigin) > 0 && opt.AllowOrigin != CorsAny && opt.isOriginAllowed(origin)
+	}
+}
+
+// WebSocketSession returns an authorization for owl.WebSocketOptions that only lets
+// upgrade requests with a valid session. Like Authorize, the user is stored in the
+// context with session.ContextKey. Otherwise, an owl.HttpError with status
+// unauthorized (401) is returned and the connection is not upgraded.
+func WebSocketSession(manager *session.Manager) func(ctx owl.Ctx) error {
+	return func(ctx owl.Ctx) error {
+		user, err := manager.ReadSessionCookie(ctx.Req)
+		if err != nil {
+			return owl.NewHttpError(http.StatusUnauthorized, err)
+		}
+		ctx.Set(session.ContextKey, user)
+		return nil
+	}
+}
@@ -4,13 +4,13 @@ import (
 	"context"
 	"embed"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
+	"reflect"
 	"slices"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/deltegui/owl/core"
@@ -39,16 +39,18 @@ import (
 // Here, v1 is a SubMux that only allows registering routes and middlewares,
 // but does not expose global Mux methods such as starting the server.
 type SubMux interface {
-	Handle(method, pattern string, handler Handler, middlewares ...Middleware)
-	Get(pattern string, handler Handler, middlewares ...Middleware)
-	Post(pattern string, handler Handler, middlewares ...Middleware)
-	Patch(pattern string, handler Handler, middlewares ...Middleware)
-	Delete(pattern string, handler Handler, middlewares ...Middleware)
-	Head(pattern string, handler Handler, middlewares ...Middleware)
-	Options(pattern string, handler Handler, middlewares ...Middleware)
-	Put(pattern string, handler Handler, middlewares ...Middleware)
-	Trace(pattern string, handler Handler, middlewares ...Middleware)
+	Handle(method, pattern string, handler Handler, middlewares ...Middleware) *Route
+	Get(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Post(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Patch(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Delete(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Head(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Options(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Put(pattern string, handler Handler, middlewares ...Middleware) *Route
+	Trace(pattern string, handler Handler, middlewares ...Middleware) *Route
 	Use(middleware Middleware)
+	SetErrorHandler(handler ErrorHandler)
+	ShowRoutes()
 }
 
 // Handler is a function that handles HTTP requests. Example:
@@ -84,7 +86,15 @@ type Mux struct {
 	cypher   core.Cypher
 	locStore *localizer.WebStore
 
-	middlewares []Middleware
+	middlewares  []Middleware
+	errorHandler ErrorHandler
+	routes       *routeTable
+	converters   *converterRegistry
+	assets       *AssetManifest
+
+	serverOptions ServerOptions
+	lifecycle     *lifecycle
+	fallback      notFoundFallback
 
 	routePrefix string
 
@@ -95,34 +105,48 @@ type Mux struct {
 // implementation to automatically do some encryptation like cookies security.
 func New(cy core.Cypher) *Mux {
 	return &Mux{
-		router:   httprouter.New(),
-		locStore: nil,
-		cypher:   cy,
-		Logger:   logx.Default{},
+		router:        httprouter.New(),
+		locStore:      nil,
+		cypher:        cy,
+		errorHandler:  DefaultErrorHandler,
+		routes:        newRouteTable(),
+		converters:    newConverterRegistry(),
+		assets:        newAssetManifest(),
+		serverOptions: DefaultServerOptions(),
+		lifecycle:     newLifecycle(),
+		Logger:        logx.Default{},
 	}
 }
 
 func (mux *Mux) createContext(w http.ResponseWriter, req *http.Request, params httprouter.Params) Ctx {
+	res := newResponseWriter(w)
 	return Ctx{
-		Req:       req,
-		Res:       w,
-		params:    params,
-		ctx:       req.Context(),
-		locstore:  mux.locStore,
-		validator: valtruc.New(),
-		cypher:    mux.cypher,
-		Logger:    mux.Logger,
+		Req:          req,
+		Res:          res,
+		params:       params,
+		state:        newCtxState(req.Context(), res, mux.converters),
+		locstore:     mux.locStore,
+		validator:    valtruc.New(),
+		cypher:       mux.cypher,
+		routes:       mux.routes,
+		assets:       mux.assets,
+		errorHandler: mux.errorHandler,
+		Logger:       mux.Logger,
 	}
 }
 
 func (mux *Mux) CreateSubMux(prefix string) SubMux {
 	return &Mux{
-		router:      mux.router,
-		locStore:    mux.locStore,
-		cypher:      mux.cypher,
-		middlewares: slices.Clone(mux.middlewares),
-		routePrefix: normalizePath(mux.routePrefix + prefix),
-		Logger:      mux.Logger.WithModuleName(prefix),
+		router:       mux.router,
+		locStore:     mux.locStore,
+		cypher:       mux.cypher,
+		middlewares:  slices.Clone(mux.middlewares),
+		errorHandler: mux.errorHandler,
+		routes:       mux.routes,
+		converters:   mux.converters,
+		assets:       mux.assets,
+		routePrefix:  normalizePath(mux.routePrefix + prefix),
+		Logger:       mux.Logger.WithModuleName(prefix),
 	}
 }
 
@@ -134,7 +158,7 @@ func (mux *Mux) CreateSubMux(prefix string) SubMux {
 //	}
 //
 //	mux.Handle(http.MethodGet, "/index", indexHandler)
-func (mux *Mux) Handle(method, pattern string, handler Handler, middlewares ...Middleware) {
+func (mux *Mux) Handle(method, pattern string, handler Handler, middlewares ...Middleware) *Route {
 	for _, m := range slices.Backward(middlewares) {
 		handler = m(handler)
 	}
@@ -142,10 +166,59 @@ func (mux *Mux) Handle(method, pattern string, handler Handler, middlewares ...M
 		handler = m(handler)
 	}
 
-	mux.router.Handle(method, normalizePath(mux.routePrefix+pattern), func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	fullPattern := normalizePath(mux.routePrefix + pattern)
+	mux.router.Handle(method, fullPattern, func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 		ctx := mux.createContext(w, req, params)
-		handler(ctx)
+		ctx.pattern = fullPattern
+		serve(ctx, handler)
 	})
+	return mux.routes.add(method, fullPattern, mux.routePrefix, append(slices.Clone(mux.middlewares), middlewares...))
+}
+
+// URL generates the URL of a named route. The params fill, in order, the
+// ':param' and '*catchall' segments of the route pattern. For example:
+//
+//	users := mux.CreateSubMux("/users")
+//	users.Get("/:id/files/*path", showFile).Name("users.file")
+//
+//	url, err := mux.URL("users.file", 42, "docs/cv.pdf") // "/users/42/files/docs/cv.pdf"
+//
+// Returns an error if the route does not exist or the number of params
+// does not match.
+func (mux *Mux) URL(name string, params ...any) (string, error) {
+	return mux.routes.url(name, params...)
+}
+
+// Routes returns all the routes registered in the mux and its sub muxes,
+// in registration order.
+func (mux *Mux) Routes() []RouteInfo {
+	return mux.routes.list()
+}
+
+// ShowRoutes prints a table with all registered routes.
+func (mux *Mux) ShowRoutes() {
+	mux.routes.print()
+}
+
+// RoutesHandler is a Handler that responds with a Json listing all registered
+// routes. Useful as a debug endpoint, but keep it behind authorization:
+//
+//	mux.Get("/debug/routes", mux.RoutesHandler(), middleware.Admin(manager, ""))
+func (mux *Mux) RoutesHandler() Handler {
+	return func(ctx Ctx) error {
+		return ctx.JsonOk(mux.Routes())
+	}
+}
+
+// serve calls the handler and sends any returned error to the ErrorHandler. Resources
+// bound to the response, like SSE streams, are closed when the handler returns.
+func serve(ctx Ctx, handler Handler) {
+	defer ctx.state.close()
+	err := handler(ctx)
+	ctx.state.close()
+	if err != nil {
+		ctx.HandleError(err)
+	}
 }
 
 func normalizePath(path string) string {
@@ -170,111 +243,239 @@ func normalizePath(path string) string {
 }
 
 // Get registers a handler to a particular pattern and HTTP Get method. See Handle method.
-func (mux *Mux) Get(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodGet, pattern, handler, middlewares...)
+func (mux *Mux) Get(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodGet, pattern, handler, middlewares...)
 }
 
 // Post registers a handler to a particular pattern and HTTP Post method. See Handle method.
-func (mux *Mux) Post(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodPost, pattern, handler, middlewares...)
+func (mux *Mux) Post(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPost, pattern, handler, middlewares...)
 }
 
 // Patch registers a handler to a particular pattern and HTTP Patch method. See Handle method.
-func (mux *Mux) Patch(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodPatch, pattern, handler, middlewares...)
+func (mux *Mux) Patch(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPatch, pattern, handler, middlewares...)
 }
 
 // Delete registers a handler to a particular pattern and HTTP Delete method. See Handle method.
-func (mux *Mux) Delete(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodDelete, pattern, handler, middlewares...)
+func (mux *Mux) Delete(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodDelete, pattern, handler, middlewares...)
 }
 
 // Head registers a handler to a particular pattern and HTTP Head method. See Handle method.
-func (mux *Mux) Head(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodHead, pattern, handler, middlewares...)
+func (mux *Mux) Head(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodHead, pattern, handler, middlewares...)
 }
 
 // Options registers a handler to a particular pattern and HTTP Options method. See Handle method.
-func (mux *Mux) Options(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodOptions, pattern, handler, middlewares...)
+func (mux *Mux) Options(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodOptions, pattern, handler, middlewares...)
 }
 
 // Put registers a handler to a particular pattern and HTTP Put method. See Handle method.
-func (mux *Mux) Put(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodPut, pattern, handler, middlewares...)
+func (mux *Mux) Put(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPut, pattern, handler, middlewares...)
 }
 
 // Trace registers a handler to a particular pattern and HTTP Trace method. See Handle method.
-func (mux *Mux) Trace(pattern string, handler Handler, middlewares ...Middleware) {
-	mux.Handle(http.MethodTrace, pattern, handler, middlewares...)
+func (mux *Mux) Trace(pattern string, handler Handler, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodTrace, pattern, handler, middlewares...)
 }
 
-// Creates a static file server in the requested dir path.
+// Creates a static file server in the requested dir path. If the requested file
+// does not exist, the NotFound handler is used.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Mux) Static(path string) {
-	//mux.router.NotFound = http.FileServer(http.Dir(path))
-	mux.router.NotFound = mux.createNotFoundHandler(http.Dir(path))
+	mux.setStatic(http.Dir(path))
 }
 
-// Creates a static file server with the requested embedded file system.
+// Creates a static file server with the requested embedded file system. If the
+// requested file does not exist, the NotFound handler is used.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Mux) StaticEmbedded(fs embed.FS) {
-	// mux.router.NotFound = http.FileServer(http.FS(fs))
-	mux.router.NotFound = mux.createNotFoundHandler(http.FS(fs))
+	mux.setStatic(http.FS(fs))
+}
+
+func (mux *Mux) setStatic(root http.FileSystem) {
+	mux.fallback.setStatic(root)
+	mux.router.NotFound = mux.fallback
+}
+
+// NotFound registers the Handler used when no route or static file matches the request.
+// Global middlewares and the provided ones are applied. The response status is not found
+// (404) unless the handler sets other. For example, render a localized page:
+//
+//	mux.NotFound(func(ctx owl.Ctx) error {
+//		return ctx.Render(templ, "NotFoundView", nil)
+//	})
+func (mux *Mux) NotFound(handler Handler, middlewares ...Middleware) {
+	mux.fallback.notFound = mux.toHttpHandler(handler, middlewares, http.StatusNotFound)
+	mux.router.NotFound = mux.fallback
+}
+
+// MethodNotAllowed registers the Handler used when a route matches the request path, but not
+// its method. The Allow header is already set when the handler is called. Global middlewares
+// and the provided ones are applied. The response status is method not allowed (405) unless
+// the handler sets other.
+func (mux *Mux) MethodNotAllowed(handler Handler, middlewares ...Middleware) {
+	mux.router.MethodNotAllowed = mux.toHttpHandler(handler, middlewares, http.StatusMethodNotAllowed)
 }
 
-func (mux *Mux) createNotFoundHandler(root http.FileSystem) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		http.FileServer(root).ServeHTTP(w, r)
+// toHttpHandler converts a Handler to a http.Handler without URL params. The response
+// status is status unless the handler sets other.
+func (mux *Mux) toHttpHandler(handler Handler, middlewares []Middleware, status int) http.Handler {
+	for _, m := range slices.Backward(middlewares) {
+		handler = m(handler)
+	}
+	for _, m := range slices.Backward(mux.middlewares) {
+		handler = m(handler)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := mux.createContext(w, req, nil)
+		ctx.Response().SetStatus(status)
+		serve(ctx, handler)
+		if !ctx.Response().Written() {
+			ctx.Response().WriteHeader(status)
+		}
 	})
 }
 
 // Creates a static file server in the requested dir URL and path.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Mux) StaticMount(url, path string) {
-	mux.router.ServeFiles(fmt.Sprintf("%s/*filepath", url), http.Dir(path))
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, newStaticFileServer(http.Dir(path)))
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
 }
 
 // Creates a static file server in the requested dir URL and embedded file system.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Mux) StaticMountEmbedded(url string, fs embed.FS) {
-	mux.router.ServeFiles(fmt.Sprintf("%s/*filepath", url), http.FS(fs))
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, newStaticFileServer(http.FS(fs)))
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
 }
 
-func startServer(server *http.Server) {
-	log.Println("Listening on address: ", server.Addr)
-	log.Println("You are ready to GO!")
-	err := server.ListenAndServe()
-	if err != nil {
-		log.Fatalln("Error while listening: ", err)
-	}
+// StaticAssets creates a static file server in the requested dir URL and path, like
+// StaticMount, and adds its files to the asset manifest. Each file is also served with
+// a fingerprinted name, like "/static/css/site.<hash>.css", and a cache lifetime of
+// one year. Assets are named by their path in the mount, so it panics if other asset
+// mount has a file with the same path. See Assets.
+func (mux *Mux) StaticAssets(url, path string) {
+	mux.mountAssets(url, http.Dir(path), os.DirFS(path))
+}
+
+// StaticAssetsEmbedded creates a static file server in the requested dir URL and
+// embedded file system, like StaticMountEmbedded, and adds its files to the asset
+// manifest. See StaticAssets.
+func (mux *Mux) StaticAssetsEmbedded(url string, fs embed.FS) {
+	mux.mountAssets(url, http.FS(fs), fs)
 }
 
-func waitAndStopServer(server *http.Server) {
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-done
+func (mux *Mux) mountAssets(url string, root http.FileSystem, fsys fs.FS) {
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, assetFileServer{
+		hashed: mux.assets.add(url, fsys),
+		files:  newStaticFileServer(root),
+	})
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
+}
 
-	log.Print("Server Stopped")
-	const maxTiemout = 5 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), maxTiemout)
+// Assets returns the manifest of the files mounted with StaticAssets and
+// StaticAssetsEmbedded. Use its FuncMap in your templates.
+func (mux *Mux) Assets() *AssetManifest {
+	return mux.assets
+}
 
-	defer func() {
-		cancel()
-	}()
+// Listen starts owl's server and stops it gracefully when the process receives
+// an interrupt or terminate signal. It will exit the process if the server fails.
+// See ListenContext if you want to handle the error.
+func (mux Mux) Listen(address string) {
+	ctx, stop := signalContext()
+	defer stop()
+	if err := mux.ListenContext(ctx, address); err != nil {
+		log.Fatalln(err)
+	}
+}
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown failed:%+v", err)
+// ListenContext starts owl's server and serves until ctx is done. Then the
+// server is stopped gracefully. Returns an error if the server cannot listen
+// or fails to shutdown.
+func (mux Mux) ListenContext(ctx context.Context, address string) error {
+	if mux.serverOptions.ShowRoutes {
+		mux.routes.print()
 	}
+	server := newServer(address, mux.router, mux.serverOptions)
+	return mux.lifecycle.run(ctx, mux.serverOptions.DrainDelay, func(ctx context.Context) error {
+		return runServer(ctx, server, mux.serverOptions, serveHTTP)
+	})
+}
 
-	log.Print("Server exited properly")
+// ListenTLS starts owl's server using HTTPS. Like Listen, the server is stopped
+// gracefully when the process receives an interrupt or terminate signal and exits
+// the process if the server fails. See TLSOptions and ListenTLSContext.
+//
+//	mux.ListenTLS(":443", owl.TLSOptions{
+//		CertFile:        "/etc/certs/site.crt",
+//		KeyFile:         "/etc/certs/site.key",
+//		RedirectAddress: ":80",
+//		HSTSMaxAge:      365 * 24 * time.Hour,
+//	})
+func (mux Mux) ListenTLS(address string, opt TLSOptions) {
+	ctx, stop := signalContext()
+	defer stop()
+	if err := mux.ListenTLSContext(ctx, address, opt); err != nil {
+		log.Fatalln(err)
+	}
 }
 
-// Listen starts owl's server
-func (mux Mux) Listen(address string) {
-	server := http.Server{
-		Addr:    address,
-		Handler: mux.router,
+// ListenTLSContext starts owl's server using HTTPS and serves until ctx is done. If
+// TLSOptions.RedirectAddress is set, a second HTTP server redirecting to HTTPS is
+// started and stopped with the main one. Returns an error if the certificates
+// cannot be loaded or any server fails.
+func (mux Mux) ListenTLSContext(ctx context.Context, address string, opt TLSOptions) error {
+	if mux.serverOptions.ShowRoutes {
+		mux.routes.print()
 	}
-	go startServer(&server)
-	waitAndStopServer(&server)
+	return mux.lifecycle.run(ctx, mux.serverOptions.DrainDelay, func(ctx context.Context) error {
+		return listenTLS(ctx, address, mux.router, mux.serverOptions, opt)
+	})
+}
+
+// OnStart registers a Hook executed before the server starts listening. Hooks
+// are executed in registration order. If a hook fails the server is not started and
+// the shutdown hooks registered before the failing hook are executed, so register each
+// OnShutdown after its OnStart. A timeout of zero means that the hook has no time limit.
+func (mux *Mux) OnStart(hook Hook, timeout time.Duration) {
+	mux.lifecycle.onStart(hook, timeout)
+}
+
+// OnShutdown registers a Hook executed after the server is stopped. Use it to
+// flush session stores, close database pools or stop background goroutines.
+// Hooks are executed in reverse registration order, like defer. A timeout of
+// zero means that the hook has no time limit.
+func (mux *Mux) OnShutdown(hook Hook, timeout time.Duration) {
+	mux.lifecycle.onShutdown(hook, timeout)
+}
+
+// IsDraining tells if the server has been requested to stop and is
+// waiting for ServerOptions.DrainDelay to close connections.
+func (mux *Mux) IsDraining() bool {
+	return mux.lifecycle.isDraining()
+}
+
+// HealthHandler is a Handler that responds Ok (200) while the server is running
+// and service unavailable (503) while its draining. Point your load balancer
+// health check to it:
+//
+//	mux.Get("/health", mux.HealthHandler())
+func (mux *Mux) HealthHandler() Handler {
+	return mux.lifecycle.healthHandler()
+}
+
+// SetServerOptions sets the options used to create the server. See ServerOptions.
+func (mux *Mux) SetServerOptions(opt ServerOptions) {
+	mux.serverOptions = opt
 }
 
 // AddLocalization creates a new WebLocalizerStore using the provided parameters.
@@ -288,6 +489,25 @@ func (mux *Mux) Use(middleware Middleware) {
 	mux.middlewares = append(mux.middlewares, middleware)
 }
 
+// SetErrorHandler replaces the ErrorHandler that receives the errors returned by handlers.
+// Like middlewares, sub muxes copy the ErrorHandler when they are created, so you can
+// set a different one for a sub mux (for example, one that always responds with Json).
+func (mux *Mux) SetErrorHandler(handler ErrorHandler) {
+	mux.errorHandler = handler
+}
+
+// AddConverter registers a Converter used by ParseForm and Bind to convert submitted
+// values to the type t. Converters are shared by the mux and all its sub muxes. For example:
+//
+//	mux.AddConverter(reflect.TypeFor[Money](), func(value string) (any, error) {
+//		return ParseMoney(value)
+//	})
+//
+// Types implementing encoding.TextUnmarshaler do not need a converter.
+func (mux *Mux) AddConverter(t reflect.Type, converter Converter) {
+	mux.converters.add(t, converter)
+}
+
 // Redirects request to URL.
 func Redirect(to string) Handler {
 	return func(c Ctx) error {
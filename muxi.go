@@ -1,10 +1,16 @@
 package owl
 
 import (
+	"context"
 	"embed"
 	"fmt"
+	"io/fs"
+	"log"
 	"net/http"
+	"os"
+	"reflect"
 	"slices"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
@@ -15,20 +21,22 @@ import (
 )
 
 type SubMuxi interface {
-	Handle(method, pattern string, builder Builder, middlewares ...Middleware)
-	Get(pattern string, builder Builder, middlewares ...Middleware)
-	Post(pattern string, builder Builder, middlewares ...Middleware)
-	Patch(pattern string, builder Builder, middlewares ...Middleware)
-	Delete(pattern string, builder Builder, middlewares ...Middleware)
-	Head(pattern string, builder Builder, middlewares ...Middleware)
-	Options(pattern string, builder Builder, middlewares ...Middleware)
-	Put(pattern string, builder Builder, middlewares ...Middleware)
-	Trace(pattern string, builder Builder, middlewares ...Middleware)
+	Handle(method, pattern string, builder Builder, middlewares ...Middleware) *Route
+	Get(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Post(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Patch(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Delete(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Head(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Options(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Put(pattern string, builder Builder, middlewares ...Middleware) *Route
+	Trace(pattern string, builder Builder, middlewares ...Middleware) *Route
 
 	Use(middleware Middleware)
+	SetErrorHandler(handler ErrorHandler)
 
 	Run(runner Runner)
 	ShowAvailableBuilders()
+	ShowRoutes()
 	PopulateStruct(s any)
 	Add(builder Builder)
 }
@@ -43,7 +51,15 @@ type Muxi struct {
 
 	injector *Injector
 
-	middlewares []Middleware
+	middlewares  []Middleware
+	errorHandler ErrorHandler
+	routes       *routeTable
+	converters   *converterRegistry
+	assets       *AssetManifest
+
+	serverOptions ServerOptions
+	lifecycle     *lifecycle
+	fallback      notFoundFallback
 
 	Logger logx.Logger
 }
@@ -52,36 +68,50 @@ type Muxi struct {
 // implementation to automatically do some encryptation like cookies security.
 func NewWithInjector(cy core.Cypher) *Muxi {
 	return &Muxi{
-		router:   httprouter.New(),
-		locStore: nil,
-		cypher:   cy,
-		injector: NewInjector(),
-		Logger:   logx.Default{},
+		router:        httprouter.New(),
+		locStore:      nil,
+		cypher:        cy,
+		injector:      NewInjector(),
+		errorHandler:  DefaultErrorHandler,
+		routes:        newRouteTable(),
+		converters:    newConverterRegistry(),
+		assets:        newAssetManifest(),
+		serverOptions: DefaultServerOptions(),
+		lifecycle:     newLifecycle(),
+		Logger:        logx.Default{},
 	}
 }
 
 func (mux *Muxi) createContext(w http.ResponseWriter, req *http.Request, params httprouter.Params) Ctx {
+	res := newResponseWriter(w)
 	return Ctx{
-		Req:       req,
-		Res:       w,
-		params:    params,
-		ctx:       req.Context(),
-		locstore:  mux.locStore,
-		validator: valtruc.New(),
-		cypher:    mux.cypher,
-		Logger:    mux.Logger,
+		Req:          req,
+		Res:          res,
+		params:       params,
+		state:        newCtxState(req.Context(), res, mux.converters),
+		locstore:     mux.locStore,
+		validator:    valtruc.New(),
+		cypher:       mux.cypher,
+		routes:       mux.routes,
+		assets:       mux.assets,
+		errorHandler: mux.errorHandler,
+		Logger:       mux.Logger,
 	}
 }
 
 func (mux *Muxi) CreateSubMuxi(prefix string) SubMuxi {
 	return &Muxi{
-		router:      mux.router,
-		locStore:    mux.locStore,
-		cypher:      mux.cypher,
-		middlewares: slices.Clone(mux.middlewares),
-		routePrefix: normalizePath(prefix),
-		injector:    mux.injector.clone(),
-		Logger:      mux.Logger.WithModuleName(prefix),
+		router:       mux.router,
+		locStore:     mux.locStore,
+		cypher:       mux.cypher,
+		middlewares:  slices.Clone(mux.middlewares),
+		errorHandler: mux.errorHandler,
+		routes:       mux.routes,
+		converters:   mux.converters,
+		assets:       mux.assets,
+		routePrefix:  normalizePath(prefix),
+		injector:     mux.injector.clone(),
+		Logger:       mux.Logger.WithModuleName(prefix),
 	}
 }
 
@@ -127,7 +157,7 @@ func (mux *Muxi) Add(builder Builder) {
 //	mux.Add(NewDependency)
 //
 // Where NewDependecy is a builder that produces the type 'dependency'.
-func (mux *Muxi) Handle(method, pattern string, builder Builder, middlewares ...Middleware) {
+func (mux *Muxi) Handle(method, pattern string, builder Builder, middlewares ...Middleware) *Route {
 	handler := mux.injector.ResolveHandler(builder)
 	for _, m := range slices.Backward(middlewares) {
 		handler = m(handler)
@@ -135,80 +165,270 @@ func (mux *Muxi) Handle(method, pattern string, builder Builder, middlewares ...
 	for _, m := range slices.Backward(mux.middlewares) {
 		handler = m(handler)
 	}
-	mux.router.Handle(method, normalizePath(mux.routePrefix+pattern), func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	fullPattern := normalizePath(mux.routePrefix + pattern)
+	mux.router.Handle(method, fullPattern, func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 		ctx := mux.createContext(w, req, params)
-		handler(ctx)
+		ctx.pattern = fullPattern
+		serve(ctx, handler)
 	})
+	return mux.routes.add(method, fullPattern, mux.routePrefix, append(slices.Clone(mux.middlewares), middlewares...))
+}
+
+// URL generates the URL of a named route. See Mux.URL.
+func (mux *Muxi) URL(name string, params ...any) (string, error) {
+	return mux.routes.url(name, params...)
+}
+
+// Routes returns all the routes registered in the mux and its sub muxes,
+// in registration order.
+func (mux *Muxi) Routes() []RouteInfo {
+	return mux.routes.list()
+}
+
+// ShowRoutes prints a table with all registered routes.
+func (mux *Muxi) ShowRoutes() {
+	mux.routes.print()
+}
+
+// RoutesHandler is a Handler that responds with a Json listing all registered
+// routes. As Handle expects a builder, register it this way:
+//
+//	mux.Get("/debug/routes", func() owl.Handler { return mux.RoutesHandler() })
+func (mux *Muxi) RoutesHandler() Handler {
+	return func(ctx Ctx) error {
+		return ctx.JsonOk(mux.Routes())
+	}
 }
 
 // Get registers a handler to a particular pattern and HTTP Get method. See Handle method.
-func (mux *Muxi) Get(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodGet, pattern, builder, middlewares...)
+func (mux *Muxi) Get(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodGet, pattern, builder, middlewares...)
 }
 
 // Post registers a handler to a particular pattern and HTTP Post method. See Handle method.
-func (mux *Muxi) Post(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodPost, pattern, builder, middlewares...)
+func (mux *Muxi) Post(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPost, pattern, builder, middlewares...)
 }
 
 // Patch registers a handler to a particular pattern and HTTP Patch method. See Handle method.
-func (mux *Muxi) Patch(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodPatch, pattern, builder, middlewares...)
+func (mux *Muxi) Patch(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPatch, pattern, builder, middlewares...)
 }
 
 // Delete registers a handler to a particular pattern and HTTP Delete method. See Handle method.
-func (mux *Muxi) Delete(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodDelete, pattern, builder, middlewares...)
+func (mux *Muxi) Delete(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodDelete, pattern, builder, middlewares...)
 }
 
 // Head registers a handler to a particular pattern and HTTP Head method. See Handle method.
-func (mux *Muxi) Head(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodHead, pattern, builder, middlewares...)
+func (mux *Muxi) Head(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodHead, pattern, builder, middlewares...)
 }
 
 // Options registers a handler to a particular pattern and HTTP Options method. See Handle method.
-func (mux *Muxi) Options(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodOptions, pattern, builder, middlewares...)
+func (mux *Muxi) Options(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodOptions, pattern, builder, middlewares...)
 }
 
 // Put registers a handler to a particular pattern and HTTP Put method. See Handle method.
-func (mux *Muxi) Put(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodPut, pattern, builder, middlewares...)
+func (mux *Muxi) Put(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodPut, pattern, builder, middlewares...)
 }
 
 // Trace registers a handler to a particular pattern and HTTP Trace method. See Handle method.
-func (mux *Muxi) Trace(pattern string, builder Builder, middlewares ...Middleware) {
-	mux.Handle(http.MethodTrace, pattern, builder, middlewares...)
+func (mux *Muxi) Trace(pattern string, builder Builder, middlewares ...Middleware) *Route {
+	return mux.Handle(http.MethodTrace, pattern, builder, middlewares...)
 }
 
-// Creates a static file server in the requested dir path.
+// Creates a static file server in the requested dir path. If the requested file
+// does not exist, the NotFound handler is used.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Muxi) Static(path string) {
-	mux.router.NotFound = http.FileServer(http.Dir(path))
+	mux.setStatic(http.Dir(path))
 }
 
-// Creates a static file server with the requested embedded file system.
+// Creates a static file server with the requested embedded file system. If the
+// requested file does not exist, the NotFound handler is used.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Muxi) StaticEmbedded(fs embed.FS) {
-	mux.router.NotFound = http.FileServer(http.FS(fs))
+	mux.setStatic(http.FS(fs))
+}
+
+func (mux *Muxi) setStatic(root http.FileSystem) {
+	mux.fallback.setStatic(root)
+	mux.router.NotFound = mux.fallback
+}
+
+// NotFound registers the Handler used when no route or static file matches the request. The
+// handler must be created using a builder. Global middlewares and the provided ones are applied.
+// See Mux.NotFound.
+func (mux *Muxi) NotFound(builder Builder, middlewares ...Middleware) {
+	mux.fallback.notFound = mux.toHttpHandler(builder, middlewares, http.StatusNotFound)
+	mux.router.NotFound = mux.fallback
+}
+
+// MethodNotAllowed registers the Handler used when a route matches the request path, but not
+// its method. The handler must be created using a builder. See Mux.MethodNotAllowed.
+func (mux *Muxi) MethodNotAllowed(builder Builder, middlewares ...Middleware) {
+	mux.router.MethodNotAllowed = mux.toHttpHandler(builder, middlewares, http.StatusMethodNotAllowed)
+}
+
+// toHttpHandler resolves a Handler and converts it to a http.Handler without URL params.
+// The response status is status unless the handler sets other.
+func (mux *Muxi) toHttpHandler(builder Builder, middlewares []Middleware, status int) http.Handler {
+	handler := mux.injector.ResolveHandler(builder)
+	for _, m := range slices.Backward(middlewares) {
+		handler = m(handler)
+	}
+	for _, m := range slices.Backward(mux.middlewares) {
+		handler = m(handler)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := mux.createContext(w, req, nil)
+		ctx.Response().SetStatus(status)
+		serve(ctx, handler)
+		if !ctx.Response().Written() {
+			ctx.Response().WriteHeader(status)
+		}
+	})
 }
 
 // Creates a static file server in the requested dir URL and path.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Muxi) StaticMount(url, path string) {
-	mux.router.ServeFiles(fmt.Sprintf("%s/*filepath", url), http.Dir(path))
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, newStaticFileServer(http.Dir(path)))
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
 }
 
 // Creates a static file server in the requested dir URL and embedded file system.
+// Files with a precompressed ".gz" sibling are served compressed to clients that accept gzip.
 func (mux *Muxi) StaticMountEmbedded(url string, fs embed.FS) {
-	mux.router.ServeFiles(fmt.Sprintf("%s/*filepath", url), http.FS(fs))
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, newStaticFileServer(http.FS(fs)))
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
+}
+
+// StaticAssets creates a static file server in the requested dir URL and path, like
+// StaticMount, and adds its files to the asset manifest. Each file is also served with
+// a fingerprinted name, like "/static/css/site.<hash>.css", and a cache lifetime of
+// one year. Assets are named by their path in the mount, so it panics if other asset
+// mount has a file with the same path. See Assets.
+func (mux *Muxi) StaticAssets(url, path string) {
+	mux.mountAssets(url, http.Dir(path), os.DirFS(path))
+}
+
+// StaticAssetsEmbedded creates a static file server in the requested dir URL and
+// embedded file system, like StaticMountEmbedded, and adds its files to the asset
+// manifest. See StaticAssets.
+func (mux *Muxi) StaticAssetsEmbedded(url string, fs embed.FS) {
+	mux.mountAssets(url, http.FS(fs), fs)
 }
 
-// Listen starts owl's server
+func (mux *Muxi) mountAssets(url string, root http.FileSystem, fsys fs.FS) {
+	pattern := fmt.Sprintf("%s/*filepath", url)
+	serveFiles(mux.router, pattern, assetFileServer{
+		hashed: mux.assets.add(url, fsys),
+		files:  newStaticFileServer(root),
+	})
+	mux.routes.add(http.MethodGet, pattern, mux.routePrefix, nil)
+}
+
+// Assets returns the manifest of the files mounted with StaticAssets and
+// StaticAssetsEmbedded. Use its FuncMap in your templates.
+func (mux *Muxi) Assets() *AssetManifest {
+	return mux.assets
+}
+
+// Listen starts owl's server and stops it gracefully when the process receives
+// an interrupt or terminate signal. It will exit the process if the server fails.
+// See ListenContext if you want to handle the error.
 func (mux Muxi) Listen(address string) {
-	server := http.Server{
-		Addr:    address,
-		Handler: mux.router,
+	ctx, stop := signalContext()
+	defer stop()
+	if err := mux.ListenContext(ctx, address); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// ListenContext starts owl's server and serves until ctx is done. Then the
+// server is stopped gracefully. Returns an error if the server cannot listen
+// or fails to shutdown.
+func (mux Muxi) ListenContext(ctx context.Context, address string) error {
+	if mux.serverOptions.ShowRoutes {
+		mux.routes.print()
+	}
+	server := newServer(address, mux.router, mux.serverOptions)
+	return mux.lifecycle.run(ctx, mux.serverOptions.DrainDelay, func(ctx context.Context) error {
+		return runServer(ctx, server, mux.serverOptions, serveHTTP)
+	})
+}
+
+// ListenTLS starts owl's server using HTTPS. Like Listen, the server is stopped
+// gracefully when the process receives an interrupt or terminate signal and exits
+// the process if the server fails. See TLSOptions and ListenTLSContext.
+//
+//	mux.ListenTLS(":443", owl.TLSOptions{
+//		CertFile:        "/etc/certs/site.crt",
+//		KeyFile:         "/etc/certs/site.key",
+//		RedirectAddress: ":80",
+//		HSTSMaxAge:      365 * 24 * time.Hour,
+//	})
+func (mux Muxi) ListenTLS(address string, opt TLSOptions) {
+	ctx, stop := signalContext()
+	defer stop()
+	if err := mux.ListenTLSContext(ctx, address, opt); err != nil {
+		log.Fatalln(err)
 	}
-	go startServer(&server)
-	waitAndStopServer(&server)
+}
+
+// ListenTLSContext starts owl's server using HTTPS and serves until ctx is done. If
+// TLSOptions.RedirectAddress is set, a second HTTP server redirecting to HTTPS is
+// started and stopped with the main one. Returns an error if the certificates
+// cannot be loaded or any server fails.
+func (mux Muxi) ListenTLSContext(ctx context.Context, address string, opt TLSOptions) error {
+	if mux.serverOptions.ShowRoutes {
+		mux.routes.print()
+	}
+	return mux.lifecycle.run(ctx, mux.serverOptions.DrainDelay, func(ctx context.Context) error {
+		return listenTLS(ctx, address, mux.router, mux.serverOptions, opt)
+	})
+}
+
+// OnStart registers a Hook executed before the server starts listening. Hooks
+// are executed in registration order. If a hook fails the server is not started and
+// the shutdown hooks registered before the failing hook are executed, so register each
+// OnShutdown after its OnStart. A timeout of zero means that the hook has no time limit.
+func (mux *Muxi) OnStart(hook Hook, timeout time.Duration) {
+	mux.lifecycle.onStart(hook, timeout)
+}
+
+// OnShutdown registers a Hook executed after the server is stopped. Use it to
+// flush session stores, close database pools or stop background goroutines.
+// Hooks are executed in reverse registration order, like defer. A timeout of
+// zero means that the hook has no time limit.
+func (mux *Muxi) OnShutdown(hook Hook, timeout time.Duration) {
+	mux.lifecycle.onShutdown(hook, timeout)
+}
+
+// IsDraining tells if the server has been requested to stop and is
+// waiting for ServerOptions.DrainDelay to close connections.
+func (mux *Muxi) IsDraining() bool {
+	return mux.lifecycle.isDraining()
+}
+
+// HealthHandler is a Handler that responds Ok (200) while the server is running
+// and service unavailable (503) while its draining. Point your load balancer
+// health check to it:
+//
+//	mux.Get("/health", func() owl.Handler { return mux.HealthHandler() })
+func (mux *Muxi) HealthHandler() Handler {
+	return mux.lifecycle.healthHandler()
+}
+
+// SetServerOptions sets the options used to create the server. See ServerOptions.
+func (mux *Muxi) SetServerOptions(opt ServerOptions) {
+	mux.serverOptions = opt
 }
 
 // AddLocalization creates a new WebLocalizerStore using the provided parameters.
@@ -221,3 +441,15 @@ func (mux *Muxi) AddLocalization(fs embed.FS, sharedKey, errorKey string) {
 func (mux *Muxi) Use(middleware Middleware) {
 	mux.middlewares = append(mux.middlewares, middleware)
 }
+
+// SetErrorHandler replaces the ErrorHandler that receives the errors returned by handlers.
+// See Mux.SetErrorHandler.
+func (mux *Muxi) SetErrorHandler(handler ErrorHandler) {
+	mux.errorHandler = handler
+}
+
+// AddConverter registers a Converter used by ParseForm and Bind to convert submitted
+// values to the type t. See Mux.AddConverter.
+func (mux *Muxi) AddConverter(t reflect.Type, converter Converter) {
+	mux.converters.add(t, converter)
+}
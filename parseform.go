@@ -1,20 +1,29 @@
 package owl
 
 import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
-
-	"github.com/deltegui/owl/core"
 )
 
 const multipartFormMaxSize = 64 << 20 // 20MB
 
+// maxFormElements limits the number of slice and map elements filled with
+// indexed form names in each request, to avoid huge allocations.
+const maxFormElements = 10000
+
+// maxFormNameDepth limits the number of dots and brackets of a form name
+// that are indexed. Deeper parts of the name are not bound.
+const maxFormNameDepth = 32
+
 // ParseForm parses req.Form and then serializes the form data to
 // the dst struct using reflection. The form names should match to
 // the 'html' tag or, if its not setted, to the field name.
-// ParseForm only supports serializing data to one-depth structs.
 // Example, using
 // this struct as target:
 //
@@ -48,13 +57,48 @@ const multipartFormMaxSize = 64 << 20 // 20MB
 //
 //   - string
 //
-//   - time.Time
+//   - time.Time. By default RFC3339 and the values sent by date and datetime-local
+//     inputs ("2006-01-02" and "2006-01-02T15:04") are accepted. Set other layout
+//     with the 'format' tag: `format:"02/01/2006"`.
+//
+//   - Types implementing encoding.TextUnmarshaler and types with a Converter
+//     (see Mux.AddConverter).
+//
+// Named types (like `type Color string`) are supported too. Named strings implementing
+// Enum only accept its values. Checkboxes values "on" and "off" are accepted for bool
+// fields, and floats can be written with a comma as decimal separator ("3,14"), but
+// without thousands separators.
+//
+// Nested structs, slices and maps are also supported using these names:
+//
+//   - Nested structs use dotted names: "Address.City".
+//
+//   - Slices of the above types are filled with repeated names: "Tags=a&Tags=b".
+//     This is how a multiple select list is submitted.
+//
+//   - Slices of any type are filled with indexed names: "Items[0].Qty=2&Items[1].Qty=5".
+//     Indexes equal or bigger than the number of submitted items (or the length of the
+//     slice, if its bigger) are ignored, so number the items from zero.
+//
+//   - Maps with string keys are filled using the key between brackets: "Attrs[color]=red".
+//
+// Each part of the name is the 'html' tag or the field name of that field. The errors
+// in ModelState for nested fields use the same path built with field names, like
+// Ctx.Validate does, so GetFormError("Address.City") works for nested fields.
+//
+// Values that cannot be converted to the type of its field (for example "abc" in an
+// int field) are kept as a ConversionError, and the field is left untouched. Call
+// Ctx.Validate after ParseForm to add these errors to ModelState. Each call to ParseForm
+// or Bind replaces the errors of the previous one. The raw submitted values are
+// available in views using ViewModel.RawValue and ViewModel.FormValue.
+//
+// Uploaded files are set to *multipart.FileHeader and []*multipart.FileHeader fields,
+// and can be validated with the 'file' tag. See FileError. If a 'file' tag is not valid,
+// the struct is not filled and the error is logged.
 func (ctx Ctx) ParseForm(dst any) {
-	contentType := ctx.Req.Header.Get("Content-Type")
-	if strings.HasPrefix(contentType, "multipart/form-data") && ctx.Req.Form == nil {
-		ctx.Req.ParseMultipartForm(multipartFormMaxSize)
-	} else if ctx.Req.Form == nil {
-		ctx.Req.ParseForm()
+	ctx.state.binding.reset()
+	if err := ctx.parseRequestForm(); err != nil {
+		ctx.Logger.WarnContext(ctx.Context(), "Cannot parse request form", "err", err)
 	}
 
 	v := reflect.ValueOf(dst)
@@ -64,188 +108,284 @@ func (ctx Ctx) ParseForm(dst any) {
 	if t.Kind() != reflect.Struct {
 		return
 	}
-	num := t.NumField()
-	for i := range num {
+	bindForm(e, requestFormData(ctx.Req), "", "", ctx.state.binding)
+	if err := ctx.state.binding.err; err != nil {
+		ctx.Logger.ErrorContext(ctx.Context(), "Cannot bind request form", "err", err)
+	}
+}
+
+// bindForm fills the struct e with form values. namePrefix is the form name of the
+// struct and pathPrefix its path, both ending with a dot if not empty.
+func bindForm(e reflect.Value, form formData, namePrefix, pathPrefix string, result *bindResult) {
+	t := e.Type()
+	fileRules, err := fileRulesOf(t)
+	if err != nil {
+		result.err = err
+		return
+	}
+	for i := range t.NumField() {
 		fieldValue := e.Field(i)
 		fieldType := t.Field(i)
-		var lookup string
-		lookup, ok := fieldType.Tag.Lookup("html")
+		lookup, ok := fieldType.Tag.Lookup(htmlTag)
 		if !ok {
+			if hasTag(fieldType, queryTag) || hasTag(fieldType, paramTag) {
+				continue
+			}
 			lookup = fieldType.Name
 		}
-		if !ctx.Req.Form.Has(lookup) {
-			continue
-		}
-		if !fieldValue.IsValid() {
+		if !fieldValue.IsValid() || !fieldValue.CanSet() {
 			continue
 		}
-		if !fieldValue.CanSet() {
-			continue
+		target := formTarget{
+			name:       namePrefix + lookup,
+			path:       pathPrefix + fieldType.Name,
+			fieldName:  fieldType.Name,
+			structName: t.Name(),
+			fileRules:  fileRules[i],
+			format:     fieldType.Tag.Get(formatTag),
 		}
-		value := ctx.Req.FormValue(lookup)
-		setValue(fieldValue, value)
+		bindFormValue(fieldValue, form, target, result)
 	}
 }
 
-func setValue(field reflect.Value, value string) bool {
+// formTarget describes where a form value is stored.
+type formTarget struct {
+	name       string
+	path       string
+	fieldName  string
+	structName string
+	fileRules  fileRules
+	format     string
+}
+
+func (target formTarget) index(key string) formTarget {
+	target.name = target.name + "[" + key + "]"
+	target.path = target.path + "[" + key + "]"
+	return target
+}
+
+func (target formTarget) fail(value string, t reflect.Type, result *bindResult) {
+	result.fail(target.path, newConversionError(target.structName, target.fieldName, t.String(), value))
+}
+
+func bindFormValue(field reflect.Value, form formData, target formTarget, result *bindResult) {
 	t := field.Type()
-	isPointer := false
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-		isPointer = true
+	if isFileType(t) {
+		bindFormFiles(field, form, target, result)
+		return
+	}
+	if result.converters.supports(t) {
+		if !form.values.Has(target.name) {
+			return
+		}
+		value := form.values.Get(target.name)
+		result.raw[target.path] = value
+		if !result.converters.setValue(field, value, target.format) && len(value) > 0 {
+			target.fail(value, t, result)
+		}
+		return
 	}
 	switch t.Kind() {
-	case reflect.String:
-		return setString(field, value, isPointer)
-	case reflect.Bool:
-		return setBool(field, value, isPointer)
 	case reflect.Struct:
-		if t.String() == "time.Time" {
-			return setDateTime(field, value, isPointer)
+		if form.hasPrefix(target.name + ".") {
+			bindForm(field, form, target.name+".", target.path+".", result)
 		}
-		return false
-
-	// Int values
-	case reflect.Int:
-		return setInt[int](field, value, isPointer, core.Size64)
-	case reflect.Int64:
-		return setInt[int64](field, value, isPointer, core.Size64)
-	case reflect.Int32:
-		return setInt[int32](field, value, isPointer, core.Size32)
-	case reflect.Int16:
-		return setInt[int16](field, value, isPointer, core.Size16)
-	case reflect.Int8:
-		return setInt[int8](field, value, isPointer, core.Size8)
-
-	// UInt values
-	case reflect.Uint:
-		return setUint[uint](field, value, isPointer, core.Size64)
-	case reflect.Uint64:
-		return setUint[uint64](field, value, isPointer, core.Size64)
-	case reflect.Uint32:
-		return setUint[uint32](field, value, isPointer, core.Size32)
-	case reflect.Uint16:
-		return setUint[uint16](field, value, isPointer, core.Size16)
-	case reflect.Uint8:
-		return setUint[uint8](field, value, isPointer, core.Size8)
-
-	// Floating numbers
-	case reflect.Float64:
-		return setFloat[float64](field, value, isPointer, core.Size64)
-	case reflect.Float32:
-		return setFloat[float32](field, value, isPointer, core.Size32)
-
-	default:
-		return false
+	case reflect.Ptr:
+		if t.Elem().Kind() != reflect.Struct || !form.hasPrefix(target.name+".") {
+			return
+		}
+		if field.IsNil() {
+			field.Set(reflect.New(t.Elem()))
+		}
+		bindForm(field.Elem(), form, target.name+".", target.path+".", result)
+	case reflect.Slice:
+		bindFormSlice(field, form, target, result)
+	case reflect.Map:
+		bindFormMap(field, form, target, result)
 	}
 }
 
-func setDateTime(field reflect.Value, value string, isPointer bool) bool {
-	if len(value) == 0 {
-		return false
+func bindFormSlice(field reflect.Value, form formData, target formTarget, result *bindResult) {
+	t := field.Type()
+	if values, ok := form.values[target.name]; ok && result.converters.supports(t.Elem()) {
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, value := range values {
+			if !result.converters.setValue(slice.Index(i), value, target.format) && len(value) > 0 {
+				target.fail(value, t.Elem(), result)
+			}
+		}
+		field.Set(slice)
+		return
 	}
-	time, err := time.Parse(time.RFC3339, value)
-	if err != nil {
-		return false
+
+	keys := form.keys(target.name)
+	limit := max(len(keys), field.Len())
+	var indexes []int
+	for _, key := range keys {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= limit {
+			continue
+		}
+		indexes = append(indexes, i)
 	}
-	if isPointer {
-		field.Set(reflect.ValueOf(&time))
-	} else {
-		field.Set(reflect.ValueOf(time))
+	if len(indexes) == 0 {
+		return
 	}
-	return true
+	length := max(slices.Max(indexes)+1, field.Len())
+	if !form.take(length-field.Len(), result) {
+		return
+	}
+	slice := reflect.MakeSlice(t, length, length)
+	reflect.Copy(slice, field)
+	for _, i := range indexes {
+		bindFormValue(slice.Index(i), form, target.index(strconv.Itoa(i)), result)
+	}
+	field.Set(slice)
 }
 
-func setInt[T int | int8 | int16 | int32 | int64](
-	field reflect.Value,
-	value string,
-	isPointer bool,
-	bits int,
-) bool {
-	i, err := strconv.ParseInt(value, 0, bits)
-	if err != nil {
-		return false
+func bindFormMap(field reflect.Value, form formData, target formTarget, result *bindResult) {
+	t := field.Type()
+	if t.Key().Kind() != reflect.String {
+		return
 	}
-	p := T(i)
-	if isPointer {
-		if value == "" {
-			return true
+	keys := form.keys(target.name)
+	if len(keys) == 0 || !form.take(len(keys), result) {
+		return
+	}
+	if field.IsNil() {
+		field.Set(reflect.MakeMap(t))
+	}
+	for _, key := range keys {
+		mapKey := reflect.ValueOf(key).Convert(t.Key())
+		elem := reflect.New(t.Elem()).Elem()
+		if current := field.MapIndex(mapKey); current.IsValid() {
+			elem.Set(current)
 		}
-		field.Set(reflect.ValueOf(&p))
-	} else {
-		field.Set(reflect.ValueOf(p))
+		bindFormValue(elem, form, target.index(key), result)
+		field.SetMapIndex(mapKey, elem)
 	}
-	return true
 }
 
-func setUint[T uint | uint8 | uint16 | uint32 | uint64](
-	field reflect.Value,
-	value string,
-	isPointer bool,
-	bits int,
-) bool {
-	i, err := strconv.ParseUint(value, 0, bits)
-	if err != nil {
-		return false
-	}
-	p := T(i)
-	if isPointer {
-		if value == "" {
-			return true
-		}
-		field.Set(reflect.ValueOf(&p))
-	} else {
-		field.Set(reflect.ValueOf(p))
+// formData are the values and files of a parsed form, and an index of its names.
+type formData struct {
+	values url.Values
+	files  map[string][]*multipart.FileHeader
+	index  *formIndex
+}
+
+// formIndex is built once per form, so nested names are found without
+// scanning all the form names for each field.
+type formIndex struct {
+	// prefixes are the parts of the names before each dot, with the dot.
+	// For example, "Items[0].Qty" adds "Items[0].".
+	prefixes map[string]bool
+	// keys are the unique keys between brackets after a name, sorted. For
+	// example, "Items[0].Qty" adds "0" to the keys of "Items".
+	keys map[string][]string
+	// elements is the number of slice and map elements that still can be created.
+	elements int
+}
+
+func requestFormData(req *http.Request) formData {
+	form := formData{values: req.Form}
+	if req.MultipartForm != nil {
+		form.files = req.MultipartForm.File
 	}
-	return true
+	form.index = newFormIndex(form)
+	return form
 }
 
-func setFloat[T float64 | float32](
-	field reflect.Value,
-	value string,
-	isPointer bool,
-	bits int,
-) bool {
-	f, err := strconv.ParseFloat(value, bits)
-	if err != nil {
-		return false
+func newFormIndex(form formData) *formIndex {
+	index := &formIndex{
+		prefixes: map[string]bool{},
+		keys:     map[string][]string{},
+		elements: maxFormElements,
 	}
-	p := T(f)
-	if isPointer {
-		if value == "" {
-			return true
+	seen := map[string]map[string]bool{}
+	addName := func(name string) {
+		depth := 0
+		for i := 0; i < len(name) && depth < maxFormNameDepth; i++ {
+			switch name[i] {
+			case '.':
+				index.prefixes[name[:i+1]] = true
+				depth++
+			case '[':
+				end := strings.IndexByte(name[i:], ']')
+				if end < 0 {
+					return
+				}
+				base, key := name[:i], name[i+1:i+end]
+				if seen[base] == nil {
+					seen[base] = map[string]bool{}
+				}
+				if !seen[base][key] {
+					seen[base][key] = true
+					index.keys[base] = append(index.keys[base], key)
+				}
+				i += end
+				depth++
+			}
 		}
-		field.Set(reflect.ValueOf(&p))
-	} else {
-		field.Set(reflect.ValueOf(p))
 	}
-	return true
+	for name := range form.values {
+		addName(name)
+	}
+	for name := range form.files {
+		addName(name)
+	}
+	for _, keys := range index.keys {
+		slices.Sort(keys)
+	}
+	return index
 }
 
-func setBool(field reflect.Value, value string, isPointer bool) bool {
-	b, err := strconv.ParseBool(value)
-	if err != nil {
-		return false
-	}
-	if isPointer {
-		if value == "" {
-			return true
+func (form formData) hasPrefix(prefix string) bool {
+	return form.index.prefixes[prefix]
+}
+
+// keys returns the unique keys between brackets after a form name. For
+// example, for the name "Items" and the form "Items[0].Qty=1&Items[1].Qty=2"
+// returns ["0", "1"].
+func (form formData) keys(name string) []string {
+	return form.index.keys[name]
+}
+
+// take reserves n slice or map elements. If the form exceeds maxFormElements,
+// a bad request error is set in result and false is returned.
+func (form formData) take(n int, result *bindResult) bool {
+	if n > form.index.elements {
+		form.index.elements = 0
+		if result.err == nil {
+			result.err = NewHttpError(http.StatusBadRequest, fmt.Errorf("form has more than %d indexed elements", maxFormElements))
 		}
-		field.Set(reflect.ValueOf(&b))
-	} else {
-		field.Set(reflect.ValueOf(b))
+		return false
 	}
+	form.index.elements -= n
 	return true
 }
 
-func setString(field reflect.Value, value string, isPointer bool) bool {
-	if isPointer {
-		if value == "" {
-			return true
-		}
-		field.Set(reflect.ValueOf(&value))
-	} else {
-		field.Set(reflect.ValueOf(value))
+// parseRequestForm parses the request form if its not already parsed. If a
+// multipart max size is set (see Ctx.SetMultipartMaxSize) bigger bodies are rejected.
+func (ctx Ctx) parseRequestForm() error {
+	if ctx.Req.Form != nil {
+		return nil
 	}
-	return true
+	maxSize := int64(multipartFormMaxSize)
+	if ctx.state.multipartMaxSize > 0 {
+		maxSize = ctx.state.multipartMaxSize
+		ctx.Req.Body = http.MaxBytesReader(ctx.Res, ctx.Req.Body, maxSize)
+	}
+	contentType := ctx.Req.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		return ctx.Req.ParseMultipartForm(maxSize)
+	}
+	return ctx.Req.ParseForm()
+}
+
+// SetMultipartMaxSize sets the maximum size in bytes of the request body when parsing
+// forms with ParseForm or Bind. Bigger bodies are rejected. If its not set, multipart
+// forms keep up to 64MB in memory, storing the rest of the files in temporary files.
+// See middleware.MultipartMaxSize to set it for a group of routes.
+func (ctx Ctx) SetMultipartMaxSize(size int64) {
+	ctx.state.multipartMaxSize = size
 }
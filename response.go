@@ -0,0 +1,191 @@
+package owl
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ResponseWriter wraps the http.ResponseWriter of a request to know what has been
+// written. It is installed in Ctx.Res for every request, so handlers use it like any
+// other http.ResponseWriter. Middlewares can read it using Ctx.Response after calling
+// the next handler. It implements http.Flusher, http.Hijacker and http.Pusher if the
+// wrapped http.ResponseWriter does.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	status      int
+	size        int64
+	start       time.Time
+	firstByte   time.Time
+	written     bool
+	hijacked    bool
+	beforeWrite []func(res *ResponseWriter)
+}
+
+func newResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{
+		ResponseWriter: w,
+		status:         http.StatusOK,
+		start:          time.Now(),
+	}
+}
+
+// Status returns the status code sent to the client. If the headers are not
+// written yet, returns the status that will be sent (200 by default).
+func (res *ResponseWriter) Status() int {
+	return res.status
+}
+
+// Size returns the number of bytes of the body written.
+func (res *ResponseWriter) Size() int64 {
+	return res.size
+}
+
+// Written tells if the headers have been sent to the client. After that, the status
+// and headers cannot be changed.
+func (res *ResponseWriter) Written() bool {
+	return res.written
+}
+
+// Hijacked tells if the connection was taken over by the handler, for example to
+// upgrade it to a WebSocket.
+func (res *ResponseWriter) Hijacked() bool {
+	return res.hijacked
+}
+
+// Duration returns the time elapsed since the request started.
+func (res *ResponseWriter) Duration() time.Duration {
+	return time.Since(res.start)
+}
+
+// TimeToFirstByte returns the time elapsed between the request start and the write of
+// the headers. Returns zero if the headers are not written yet.
+func (res *ResponseWriter) TimeToFirstByte() time.Duration {
+	if !res.written {
+		return 0
+	}
+	return res.firstByte.Sub(res.start)
+}
+
+// BeforeWrite registers a function called just before the headers are written. Use it
+// to set headers or cookies that depend on the handler result. Functions are called in
+// reverse order of registration, like defer, so outer middlewares have the last word.
+func (res *ResponseWriter) BeforeWrite(fn func(res *ResponseWriter)) {
+	res.beforeWrite = append(res.beforeWrite, fn)
+}
+
+// WriteHeader sends the headers with the status code. Informational codes (1xx) other
+// than 101 can be sent before the final status.
+func (res *ResponseWriter) WriteHeader(status int) {
+	if res.written {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		res.ResponseWriter.WriteHeader(status)
+		return
+	}
+	res.status = status
+	hooks := res.beforeWrite
+	res.beforeWrite = nil
+	for _, hook := range slices.Backward(hooks) {
+		hook(res)
+	}
+	res.written = true
+	res.firstByte = time.Now()
+	res.ResponseWriter.WriteHeader(res.status)
+}
+
+// SetStatus changes the status that will be written. The before write functions can
+// use it. Does nothing if the headers are already written.
+func (res *ResponseWriter) SetStatus(status int) {
+	if !res.written {
+		res.status = status
+	}
+}
+
+func (res *ResponseWriter) Write(buffer []byte) (int, error) {
+	if !res.written {
+		res.WriteHeader(res.status)
+	}
+	n, err := res.ResponseWriter.Write(buffer)
+	res.size += int64(n)
+	return n, err
+}
+
+// ReadFrom lets io.Copy use the ReaderFrom of the wrapped http.ResponseWriter (for
+// example, to use sendfile when serving static files).
+func (res *ResponseWriter) ReadFrom(reader io.Reader) (int64, error) {
+	if !res.written {
+		res.WriteHeader(res.status)
+	}
+	var n int64
+	var err error
+	if readerFrom, ok := res.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(reader)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{res.ResponseWriter}, reader)
+	}
+	res.size += n
+	return n, err
+}
+
+// Flush sends the buffered data to the client. Implements http.Flusher.
+func (res *ResponseWriter) Flush() {
+	res.FlushError()
+}
+
+// FlushError works like Flush but returns an error if the wrapped http.ResponseWriter
+// does not support flushing. Is used by http.ResponseController.
+func (res *ResponseWriter) FlushError() error {
+	if !res.written {
+		res.WriteHeader(res.status)
+	}
+	return http.NewResponseController(res.ResponseWriter).Flush()
+}
+
+// Hijack lets the handler take over the connection. Implements http.Hijacker.
+func (res *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(res.ResponseWriter).Hijack()
+	if err == nil {
+		res.hijacked = true
+		res.written = true
+		res.status = http.StatusSwitchingProtocols
+		res.firstByte = time.Now()
+	}
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push. Implements http.Pusher.
+func (res *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := res.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter. Is used by http.ResponseController.
+func (res *ResponseWriter) Unwrap() http.ResponseWriter {
+	return res.ResponseWriter
+}
+
+// Response returns the ResponseWriter of the request. See ResponseWriter.
+func (ctx Ctx) Response() *ResponseWriter {
+	return ctx.state.response
+}
+
+// Written tells if the response headers have been sent. ErrorHandlers use it
+// to avoid writing a response twice.
+func (ctx Ctx) Written() bool {
+	return ctx.state.response != nil && ctx.state.response.Written()
+}
+
+// BeforeWrite registers a function called just before the response headers are
+// written. See ResponseWriter.BeforeWrite.
+func (ctx Ctx) BeforeWrite(fn func(res *ResponseWriter)) {
+	ctx.state.response.BeforeWrite(fn)
+}
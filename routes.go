@@ -0,0 +1,195 @@
+package owl
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Route is a registered route. It is returned by the Handle family of
+// methods and lets you give a name to the route:
+//
+//	mux.Get("/account/login", showLogin).Name("account.login")
+//
+// Then you can generate the URL of the route by its name. See Mux.URL.
+type Route struct {
+	method      string
+	pattern     string
+	prefix      string
+	middlewares []string
+	name        string
+	table       *routeTable
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method string `json:"method"`
+
+	// Pattern is the full normalized pattern, including the sub mux prefix.
+	Pattern string `json:"pattern"`
+
+	// Prefix is the prefix of the sub mux where the route was registered.
+	Prefix string `json:"prefix"`
+
+	Name string `json:"name,omitempty"`
+
+	// Middlewares is the number of middlewares that wrap the handler, including
+	// the global ones. MiddlewareNames has the function name of each one, in
+	// execution order.
+	Middlewares     int      `json:"middlewares"`
+	MiddlewareNames []string `json:"middlewareNames"`
+}
+
+// Name sets the name of the route. Names must be unique; registering the same
+// name twice will panic.
+func (route *Route) Name(name string) *Route {
+	route.table.setName(route, name)
+	return route
+}
+
+func (route *Route) url(params ...any) (string, error) {
+	segments := strings.Split(route.pattern, "/")
+	current := 0
+	for i, segment := range segments {
+		if len(segment) == 0 {
+			continue
+		}
+		if segment[0] != ':' && segment[0] != '*' {
+			continue
+		}
+		if current >= len(params) {
+			return "", fmt.Errorf("not enough params to generate URL for route '%s' (%s)", route.name, route.pattern)
+		}
+		value := fmt.Sprint(params[current])
+		current++
+		if segment[0] == ':' {
+			segments[i] = url.PathEscape(value)
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+		for j, part := range parts {
+			parts[j] = url.PathEscape(part)
+		}
+		segments[i] = strings.Join(parts, "/")
+	}
+	if current != len(params) {
+		return "", fmt.Errorf("too many params to generate URL for route '%s' (%s)", route.name, route.pattern)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// routeTable stores every route registered in a Mux and its sub muxes.
+type routeTable struct {
+	mutex  sync.RWMutex
+	routes []*Route
+	names  map[string]*Route
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		names: make(map[string]*Route),
+	}
+}
+
+func (table *routeTable) add(method, pattern, prefix string, middlewares []Middleware) *Route {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	names := make([]string, 0, len(middlewares))
+	for _, m := range middlewares {
+		names = append(names, funcName(m))
+	}
+	route := &Route{
+		method:      method,
+		pattern:     pattern,
+		prefix:      prefix,
+		middlewares: names,
+		table:       table,
+	}
+	table.routes = append(table.routes, route)
+	return route
+}
+
+func (table *routeTable) list() []RouteInfo {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	infos := make([]RouteInfo, 0, len(table.routes))
+	for _, route := range table.routes {
+		infos = append(infos, RouteInfo{
+			Method:          route.method,
+			Pattern:         route.pattern,
+			Prefix:          route.prefix,
+			Name:            route.name,
+			Middlewares:     len(route.middlewares),
+			MiddlewareNames: slices.Clone(route.middlewares),
+		})
+	}
+	return infos
+}
+
+// print logs a table with all registered routes.
+func (table *routeTable) print() {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "METHOD\tPATTERN\tNAME\tMIDDLEWARES")
+	for _, info := range table.list() {
+		middlewares := strconv.Itoa(info.Middlewares)
+		if info.Middlewares > 0 {
+			middlewares += " (" + strings.Join(info.MiddlewareNames, ", ") + ")"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", info.Method, info.Pattern, info.Name, middlewares)
+	}
+	writer.Flush()
+	log.Printf("Registered routes:\n%s", builder.String())
+}
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// funcName returns the package and function name of a middleware. For example,
+// the middleware returned by middleware.Authorize is named "middleware.Authorize".
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+func (table *routeTable) setName(route *Route, name string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	if other, ok := table.names[name]; ok && other != route {
+		log.Panicf("Route name '%s' is already used by %s %s\n", name, other.method, other.pattern)
+	}
+	if len(route.name) > 0 {
+		delete(table.names, route.name)
+	}
+	route.name = name
+	table.names[name] = route
+}
+
+// url generates the URL of a named route. The params fill, in order, the
+// ':param' and '*catchall' segments of the route pattern.
+func (table *routeTable) url(name string, params ...any) (string, error) {
+	if table == nil {
+		return "", fmt.Errorf("cannot generate URL for route '%s': no routes available", name)
+	}
+	table.mutex.RLock()
+	route, ok := table.names[name]
+	table.mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("route with name '%s' not found", name)
+	}
+	return route.url(params...)
+}
@@ -0,0 +1,146 @@
+package owl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+// ServerOptions configures the http.Server created when you call Listen
+// or ListenContext. A zero timeout means no timeout. Start from
+// DefaultServerOptions and change what you need:
+//
+//	opt := owl.DefaultServerOptions()
+//	opt.WriteTimeout = 2 * time.Minute
+//	mux.SetServerOptions(opt)
+type ServerOptions struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// ShutdownTimeout is the time given to active connections to finish
+	// when the server is stopped. By default 5 seconds.
+	ShutdownTimeout time.Duration
+
+	// DrainDelay is the time the server keeps serving in draining state
+	// after a stop is requested and before connections are closed. Use it
+	// to give load balancers time to see the health endpoint failing and
+	// stop sending traffic. By default zero. See Mux.HealthHandler.
+	DrainDelay time.Duration
+
+	TLSConfig *tls.Config
+
+	// Listener lets you provide your own net.Listener. If its nil, a
+	// TCP listener is created using the address passed to Listen.
+	Listener net.Listener
+
+	// BaseContext returns the base context.Context of every request.
+	BaseContext func(net.Listener) context.Context
+
+	// ShowRoutes prints the table of registered routes when the server
+	// starts. By default false. See Mux.ShowRoutes.
+	ShowRoutes bool
+}
+
+// DefaultServerOptions returns the ServerOptions used by default.
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+		ShutdownTimeout:   defaultShutdownTimeout,
+	}
+}
+
+func newServer(address string, handler http.Handler, opt ServerOptions) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadTimeout:       opt.ReadTimeout,
+		ReadHeaderTimeout: opt.ReadHeaderTimeout,
+		WriteTimeout:      opt.WriteTimeout,
+		IdleTimeout:       opt.IdleTimeout,
+		MaxHeaderBytes:    opt.MaxHeaderBytes,
+		TLSConfig:         opt.TLSConfig,
+		BaseContext:       opt.BaseContext,
+	}
+}
+
+// signalContext returns a context.Context that is cancelled when the
+// process receives an interrupt or terminate signal.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+}
+
+// serveFunc serves HTTP requests using a listener. See serveHTTP and serveTLS.
+type serveFunc func(server *http.Server, listener net.Listener) error
+
+func serveHTTP(server *http.Server, listener net.Listener) error {
+	return server.Serve(listener)
+}
+
+// serveTLS serves HTTPS requests. The certificates must be configured in server.TLSConfig.
+func serveTLS(server *http.Server, listener net.Listener) error {
+	return server.ServeTLS(listener, "", "")
+}
+
+// runServer serves until ctx is done or the server fails. Then, it stops
+// the server gracefully.
+func runServer(ctx context.Context, server *http.Server, opt ServerOptions, serve serveFunc) error {
+	listener := opt.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			return fmt.Errorf("cannot listen on address '%s': %w", server.Addr, err)
+		}
+	}
+	serveErr := make(chan error, 1)
+	go startServer(server, listener, serve, serveErr)
+	return waitAndStopServer(ctx, server, opt.ShutdownTimeout, serveErr)
+}
+
+func startServer(server *http.Server, listener net.Listener, serve serveFunc, serveErr chan<- error) {
+	log.Println("Listening on address: ", listener.Addr())
+	log.Println("You are ready to GO!")
+	serveErr <- serve(server, listener)
+}
+
+func waitAndStopServer(ctx context.Context, server *http.Server, timeout time.Duration, serveErr <-chan error) error {
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("error while listening: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Print("Server Stopped")
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server shutdown failed: %w", err)
+	}
+
+	log.Print("Server exited properly")
+	return nil
+}
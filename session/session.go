@@ -105,6 +105,13 @@ type ManagerConfiguration struct {
 	// HTTP and HTTPS.
 	Secure bool
 
+	// SecureAuto sets the cookie "secure" parameter only
+	// when the request is made with HTTPS. Its ignored if
+	// Secure is true. Behind a reverse proxy, use
+	// middleware.TrustedProxies so the scheme of the client
+	// is used.
+	SecureAuto bool
+
 	// Invlidate enabled session renew feature. If is enabled
 	// invalidates old sessions every time user logins. If it
 	// is not enabled old sessions remains valid util expires.
@@ -194,7 +201,7 @@ func (manager *Manager) GetUserIfValid(id Id) (User, error) {
 
 const cookieKey string = "phx_session"
 
-func (manager *Manager) CreateSessionCookie(w http.ResponseWriter, user User) error {
+func (manager *Manager) CreateSessionCookie(w http.ResponseWriter, req *http.Request, user User) error {
 	entry := manager.Add(user)
 	age := core.OneDayDuration
 	encoded, err := cypher.EncodeCookie(manager.cypher, string(entry.Id))
@@ -209,11 +216,19 @@ func (manager *Manager) CreateSessionCookie(w http.ResponseWriter, user User) er
 		Path:     "/",
 		SameSite: http.SameSiteDefaultMode,
 		HttpOnly: true,
-		Secure:   manager.configuration.Secure,
+		Secure:   manager.isSecure(req),
 	})
 	return nil
 }
 
+// isSecure tells if the session cookie is only sent over HTTPS.
+func (manager *Manager) isSecure(req *http.Request) bool {
+	if manager.configuration.Secure {
+		return true
+	}
+	return manager.configuration.SecureAuto && core.Scheme(req) == "https"
+}
+
 func readSessionId(req *http.Request, cy core.Cypher) (Id, *http.Cookie, error) {
 	cookie, err := req.Cookie(cookieKey)
 	if err != nil {
@@ -252,7 +267,7 @@ func (manager *Manager) DestroySession(w http.ResponseWriter, req *http.Request)
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   manager.configuration.Secure,
+		Secure:   manager.isSecure(req),
 	})
 	return nil
 }
@@ -0,0 +1,362 @@
+package owl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastEventIdHeader is the header sent by browsers when they reconnect to an event stream.
+const lastEventIdHeader = "Last-Event-ID"
+
+// sseSubscriberBuffer is the number of events buffered for each client of a SSEBroker.
+const sseSubscriberBuffer = 32
+
+// SSEStream is a Server-Sent Events stream opened with Ctx.SSE. All methods are safe
+// to be called from different goroutines. Writes fail once the client disconnects or
+// the stream is closed.
+type SSEStream struct {
+	mutex       sync.Mutex
+	res         http.ResponseWriter
+	controller  *http.ResponseController
+	ctx         context.Context
+	cancel      context.CancelFunc
+	heartbeats  sync.WaitGroup
+	lastEventId string
+}
+
+// SSE starts a Server-Sent Events stream. Sets the event stream headers and sends
+// them to the client. The stream lives until the handler returns or the client
+// disconnects (see SSEStream.Done), so the server WriteTimeout is disabled for
+// this request. The stream is closed when the handler returns. For example:
+//
+//	func events(ctx owl.Ctx) error {
+//		stream, err := ctx.SSE()
+//		if err != nil {
+//			return err
+//		}
+//		stream.Heartbeat(15 * time.Second)
+//		for {
+//			select {
+//			case <-stream.Done():
+//				return nil
+//			case stat := <-stats:
+//				if err := stream.SendJson("stats", "", stat); err != nil {
+//					return nil
+//				}
+//			}
+//		}
+//	}
+func (ctx Ctx) SSE() (*SSEStream, error) {
+	streamCtx, cancel := context.WithCancel(ctx.Context())
+	stream := &SSEStream{
+		res:         ctx.Res,
+		controller:  http.NewResponseController(ctx.Res),
+		ctx:         streamCtx,
+		cancel:      cancel,
+		lastEventId: ctx.Req.Header.Get(lastEventIdHeader),
+	}
+	// The response cannot be written after the handler returns.
+	ctx.state.onClose(stream.Close)
+	header := ctx.Res.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	// Streams live longer than the server WriteTimeout.
+	stream.controller.SetWriteDeadline(time.Time{})
+	ctx.Res.WriteHeader(http.StatusOK)
+	if err := stream.controller.Flush(); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("cannot start event stream: %w", err)
+	}
+	return stream, nil
+}
+
+// LastEventId returns the id of the last event received by the client before
+// reconnecting. Its empty for new clients. Use it to send the missed events.
+func (stream *SSEStream) LastEventId() string {
+	return stream.lastEventId
+}
+
+// Done is closed when the client disconnects or the stream is closed.
+func (stream *SSEStream) Done() <-chan struct{} {
+	return stream.ctx.Done()
+}
+
+// Close stops the stream and waits for the heartbeat to finish. Next writes fail.
+// Its called when the handler returns, so you only need it to end the stream before.
+func (stream *SSEStream) Close() {
+	stream.cancel()
+	stream.heartbeats.Wait()
+}
+
+// Send writes an event to the client. The event name and the id are optional. Data
+// with multiple lines is sent as multiple data fields, so the client receives the
+// same lines.
+func (stream *SSEStream) Send(event, id, data string) error {
+	var builder strings.Builder
+	if len(event) > 0 {
+		fmt.Fprintf(&builder, "event: %s\n", sanitizeSSEField(event))
+	}
+	if len(id) > 0 {
+		fmt.Fprintf(&builder, "id: %s\n", sanitizeSSEField(id))
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		fmt.Fprintf(&builder, "data: %s\n", line)
+	}
+	builder.WriteString("\n")
+	return stream.write(builder.String())
+}
+
+// SendJson writes an event whose data is the Json representation of data.
+func (stream *SSEStream) SendJson(event, id string, data any) error {
+	buffer, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal event data: %w", err)
+	}
+	return stream.Send(event, id, string(buffer))
+}
+
+// SendEvent writes an SSEEvent to the client.
+func (stream *SSEStream) SendEvent(event SSEEvent) error {
+	return stream.Send(event.Event, event.Id, event.Data)
+}
+
+// Comment writes a comment. Comments are ignored by clients, but keep the connection alive.
+func (stream *SSEStream) Comment(text string) error {
+	return stream.write(fmt.Sprintf(": %s\n\n", sanitizeSSEField(text)))
+}
+
+// Retry tells the client how long to wait before reconnecting.
+func (stream *SSEStream) Retry(delay time.Duration) error {
+	return stream.write(fmt.Sprintf("retry: %d\n\n", delay.Milliseconds()))
+}
+
+// Heartbeat sends a comment every interval until the client disconnects or the
+// stream is closed. This avoids proxies closing idle connections. An interval
+// of zero or less does nothing.
+func (stream *SSEStream) Heartbeat(interval time.Duration) {
+	if interval <= 0 || stream.ctx.Err() != nil {
+		return
+	}
+	stream.heartbeats.Add(1)
+	go func() {
+		defer stream.heartbeats.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stream.Done():
+				return
+			case <-ticker.C:
+				if err := stream.Comment("heartbeat"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (stream *SSEStream) write(message string) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if err := stream.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := stream.res.Write([]byte(message)); err != nil {
+		return err
+	}
+	return stream.controller.Flush()
+}
+
+// sanitizeSSEField removes line breaks, that would end the field.
+func sanitizeSSEField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// SSEEvent is an event published with a SSEBroker.
+type SSEEvent struct {
+	Event string
+	Id    string
+	Data  string
+}
+
+type sseSubscriber struct {
+	events chan SSEEvent
+	closed chan struct{}
+}
+
+type sseTopic struct {
+	subscribers map[*sseSubscriber]struct{}
+	history     []SSEEvent
+}
+
+// SSEBroker sends the events published on a topic to all the clients subscribed to
+// that topic. It keeps the last events of each topic, so clients reconnecting with
+// a Last-Event-ID receive the events they missed. Clients too slow to receive the
+// events are disconnected, so they can reconnect and resume. For example:
+//
+//	broker := owl.NewSSEBroker(100)
+//	mux.Get("/dashboard/events", broker.Handler("dashboard"))
+//
+//	broker.PublishJson("dashboard", "stats", stats)
+type SSEBroker struct {
+	mutex       sync.Mutex
+	topics      map[string]*sseTopic
+	historySize int
+	lastId      uint64
+}
+
+// NewSSEBroker creates a SSEBroker that keeps the last historySize events of each topic.
+func NewSSEBroker(historySize int) *SSEBroker {
+	return &SSEBroker{
+		topics:      map[string]*sseTopic{},
+		historySize: historySize,
+	}
+}
+
+func (broker *SSEBroker) topic(name string) *sseTopic {
+	topic, ok := broker.topics[name]
+	if !ok {
+		topic = &sseTopic{subscribers: map[*sseSubscriber]struct{}{}}
+		broker.topics[name] = topic
+	}
+	return topic
+}
+
+// Publish sends the event to all the clients of the topic. If the event has no Id,
+// the broker sets a sequential one.
+func (broker *SSEBroker) Publish(topicName string, event SSEEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if len(event.Id) == 0 {
+		broker.lastId++
+		event.Id = strconv.FormatUint(broker.lastId, 10)
+	}
+	topic := broker.topic(topicName)
+	if broker.historySize > 0 {
+		topic.history = append(topic.history, event)
+		if len(topic.history) > broker.historySize {
+			topic.history = topic.history[len(topic.history)-broker.historySize:]
+		}
+	}
+	for subscriber := range topic.subscribers {
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Printf("SSE client of topic %s is too slow. Disconnecting it\n", topicName)
+			delete(topic.subscribers, subscriber)
+			close(subscriber.closed)
+		}
+	}
+}
+
+// PublishJson sends an event whose data is the Json representation of data.
+func (broker *SSEBroker) PublishJson(topicName, event string, data any) error {
+	buffer, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal event data: %w", err)
+	}
+	broker.Publish(topicName, SSEEvent{Event: event, Data: string(buffer)})
+	return nil
+}
+
+// Subscribers returns the number of clients connected to a topic.
+func (broker *SSEBroker) Subscribers(topicName string) int {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if topic, ok := broker.topics[topicName]; ok {
+		return len(topic.subscribers)
+	}
+	return 0
+}
+
+func (broker *SSEBroker) subscribe(topicName, lastEventId string) (*sseSubscriber, []SSEEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	topic := broker.topic(topicName)
+	subscriber := &sseSubscriber{
+		events: make(chan SSEEvent, sseSubscriberBuffer),
+		closed: make(chan struct{}),
+	}
+	topic.subscribers[subscriber] = struct{}{}
+	var missed []SSEEvent
+	if len(lastEventId) > 0 {
+		for i, event := range topic.history {
+			if event.Id == lastEventId {
+				missed = append(missed, topic.history[i+1:]...)
+				break
+			}
+		}
+	}
+	return subscriber, missed
+}
+
+func (broker *SSEBroker) unsubscribe(topicName string, subscriber *sseSubscriber) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	topic, ok := broker.topics[topicName]
+	if !ok {
+		return
+	}
+	if _, ok := topic.subscribers[subscriber]; ok {
+		delete(topic.subscribers, subscriber)
+		close(subscriber.closed)
+	}
+	if len(topic.subscribers) == 0 && len(topic.history) == 0 {
+		delete(broker.topics, topicName)
+	}
+}
+
+// Serve subscribes the stream to the topic. First, sends the events missed by the
+// client (see SSEStream.LastEventId) and then, all new events. Blocks until the
+// client disconnects or is too slow to receive events.
+func (broker *SSEBroker) Serve(topicName string, stream *SSEStream) error {
+	subscriber, missed := broker.subscribe(topicName, stream.LastEventId())
+	defer broker.unsubscribe(topicName, subscriber)
+	for _, event := range missed {
+		if err := stream.SendEvent(event); err != nil {
+			return ignoreCanceled(err)
+		}
+	}
+	for {
+		select {
+		case <-stream.Done():
+			return nil
+		case <-subscriber.closed:
+			return nil
+		case event := <-subscriber.events:
+			if err := stream.SendEvent(event); err != nil {
+				return ignoreCanceled(err)
+			}
+		}
+	}
+}
+
+// Handler returns a Handler that opens an event stream subscribed to the topic.
+func (broker *SSEBroker) Handler(topicName string) Handler {
+	return func(ctx Ctx) error {
+		stream, err := ctx.SSE()
+		if err != nil {
+			return err
+		}
+		return broker.Serve(topicName, stream)
+	}
+}
+
+// ignoreCanceled returns nil for errors produced by client disconnections.
+func ignoreCanceled(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package owl
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// precompressedExtension is the extension of the gzip siblings of static files.
+const precompressedExtension = ".gz"
+
+// staticFileServer serves the files of root like http.FileServer. If a file has a
+// precompressed sibling (for example "app.js.gz" for "app.js") and the client
+// accepts gzip, the sibling is served with the Content-Type of the original file.
+type staticFileServer struct {
+	root  http.FileSystem
+	files http.Handler
+}
+
+func newStaticFileServer(root http.FileSystem) http.Handler {
+	return staticFileServer{
+		root:  root,
+		files: http.FileServer(root),
+	}
+}
+
+func (server staticFileServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, "/") {
+		server.files.ServeHTTP(w, req)
+		return
+	}
+	name := path.Clean("/" + req.URL.Path)
+	file, err := server.root.Open(name + precompressedExtension)
+	if err != nil {
+		server.files.ServeHTTP(w, req)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		server.files.ServeHTTP(w, req)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		server.files.ServeHTTP(w, req)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(w, req, name, info.ModTime(), file)
+}
+
+// acceptsGzip tells if the Accept-Encoding header allows gzip.
+func acceptsGzip(req *http.Request) bool {
+	accepted := false
+	for _, value := range headerValues(req.Header, "Accept-Encoding") {
+		coding, params, _ := strings.Cut(value, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if qValue, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(qValue, 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == "gzip" {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// serveFiles registers a static file server for the pattern, that must end with "/*filepath".
+func serveFiles(router *httprouter.Router, pattern string, fileServer http.Handler) {
+	if !strings.HasSuffix(pattern, "/*filepath") {
+		panic(fmt.Sprintf("path '%s' must end with /*filepath", pattern))
+	}
+	router.GET(pattern, func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		req.URL.Path = params.ByName("filepath")
+		fileServer.ServeHTTP(w, req)
+	})
+}
@@ -88,6 +88,21 @@ func mapKeyExists(m map[string]any, key string) bool {
 	return ok
 }
 
+// routeURL generates the URL of a named route. Use it in your views this way:
+//
+//	<a href="{{ URL . "users.show" .Model.Id }}">Profile</a>
+func routeURL(vm owl.ViewModel, name string, params ...any) (string, error) {
+	return vm.Ctx.URL(name, params...)
+}
+
+// cspNonce places the nonce attribute of the request in inline scripts and styles.
+// See middleware.SecureHeaders:
+//
+//	<script {{ CspNonce . }}>console.log("allowed")</script>
+func cspNonce(vm owl.ViewModel) template.HTMLAttr {
+	return vm.PlaceCspNonce()
+}
+
 func CreateDefaultFuncMap() template.FuncMap {
 	return template.FuncMap{
 		"Uppercase":      upperCase,
@@ -105,5 +120,7 @@ func CreateDefaultFuncMap() template.FuncMap {
 		"Map":            paramsMap,
 		"MapKeyExists":   mapKeyExists,
 		"ToHTML":         func(input string) template.HTML { return template.HTML(input) },
+		"URL":            routeURL,
+		"CspNonce":       cspNonce,
 	}
 }
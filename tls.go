@@ -0,0 +1,197 @@
+package owl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TLSOptions configures how owl serves HTTPS. See Mux.ListenTLS.
+type TLSOptions struct {
+	// CertFile and KeyFile are the paths to the PEM encoded certificate
+	// and private key. Both are reloaded when the process receives SIGHUP,
+	// so you can renew your certificates without restarting the server.
+	CertFile string
+	KeyFile  string
+
+	// RedirectAddress is the address of an optional plain HTTP listener
+	// that redirects every request to HTTPS. For example ":80". If its
+	// empty no HTTP listener is started.
+	RedirectAddress string
+
+	// HSTSMaxAge enables the Strict-Transport-Security header when is
+	// greater than zero.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+}
+
+// certReloader keeps the current certificate and reloads it from disk.
+type certReloader struct {
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate '%s' with key '%s': %w", reloader.certFile, reloader.keyFile, err)
+	}
+	reloader.mutex.Lock()
+	reloader.cert = &cert
+	reloader.mutex.Unlock()
+	return nil
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.cert, nil
+}
+
+// watch reloads the certificate every time the process receives SIGHUP until ctx is done.
+// If the new certificate cannot be loaded, the old one is kept.
+func (reloader *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reloader.reload(); err != nil {
+				log.Println("Error while reloading TLS certificate, keeping the old one: ", err)
+				continue
+			}
+			log.Println("TLS certificate reloaded")
+		}
+	}
+}
+
+func hstsHeader(opt TLSOptions) string {
+	value := "max-age=" + strconv.Itoa(int(opt.HSTSMaxAge.Seconds()))
+	if opt.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if opt.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+func withHSTS(handler http.Handler, opt TLSOptions) http.Handler {
+	if opt.HSTSMaxAge <= 0 {
+		return handler
+	}
+	header := hstsHeader(opt)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Strict-Transport-Security", header)
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// createRedirectHandler redirects any request to the same host and path using HTTPS. The
+// port of tlsAddress is added to the host if its not the default HTTPS port.
+func createRedirectHandler(tlsAddress string) http.Handler {
+	_, port, err := net.SplitHostPort(tlsAddress)
+	if err != nil || port == "443" {
+		port = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if len(port) == 0 && strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+		if len(port) > 0 {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		code := http.StatusPermanentRedirect
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), code)
+	})
+}
+
+// listenTLS serves HTTPS (and optionally the HTTP redirect listener) until ctx is done.
+func listenTLS(ctx context.Context, address string, handler http.Handler, serverOpt ServerOptions, opt TLSOptions) error {
+	reloader, err := newCertReloader(opt.CertFile, opt.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go reloader.watch(ctx)
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if serverOpt.TLSConfig != nil {
+		tlsConfig = serverOpt.TLSConfig.Clone()
+	}
+	tlsConfig.GetCertificate = reloader.getCertificate
+
+	// redirectErr is nil without redirect listener, so receiving from it blocks forever.
+	var redirectErr chan error
+	if len(opt.RedirectAddress) > 0 {
+		redirectOpt := serverOpt
+		redirectOpt.TLSConfig = nil
+		// Bind it before serving, so the app does not run without redirect.
+		redirectOpt.Listener, err = net.Listen("tcp", opt.RedirectAddress)
+		if err != nil {
+			return fmt.Errorf("cannot listen on redirect address '%s': %w", opt.RedirectAddress, err)
+		}
+		redirect := newServer(opt.RedirectAddress, createRedirectHandler(address), redirectOpt)
+		redirectErr = make(chan error, 1)
+		go func() {
+			redirectErr <- runServer(ctx, redirect, redirectOpt, serveHTTP)
+		}()
+	}
+
+	serverOpt.TLSConfig = tlsConfig
+	server := newServer(address, withHSTS(handler, opt), serverOpt)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- runServer(ctx, server, serverOpt, serveTLS)
+	}()
+
+	select {
+	case err = <-serverErr:
+		cancel()
+		if redirectErr != nil {
+			err = errors.Join(err, <-redirectErr)
+		}
+		return err
+	case err = <-redirectErr:
+		// The redirect server only stops before ctx is done if it fails.
+		cancel()
+		return errors.Join(err, <-serverErr)
+	}
+}
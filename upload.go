@@ -0,0 +1,267 @@
+package owl
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deltegui/owl/core"
+	"github.com/deltegui/valtruc"
+)
+
+// Identifiers of the ModelState errors produced when an uploaded file does not
+// match the rules of its 'file' tag. Localize them like any other validation identifier.
+const (
+	FileSizeErrorIdentifier  valtruc.ValidatorIdentifier = "file.size"
+	FileTypeErrorIdentifier  valtruc.ValidatorIdentifier = "file.type"
+	FileCountErrorIdentifier valtruc.ValidatorIdentifier = "file.count"
+)
+
+// fileTag is the struct tag with the rules of an upload field.
+const fileTag = "file"
+
+// sniffLength is the number of bytes used by http.DetectContentType.
+const sniffLength = 512
+
+var (
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+// isFileType tells if the type is *multipart.FileHeader or []*multipart.FileHeader.
+func isFileType(t reflect.Type) bool {
+	return t == fileHeaderType || t == fileHeadersType
+}
+
+// fileRules are the rules of a 'file' tag. For example:
+//
+//	type profileForm struct {
+//		Avatar *multipart.FileHeader   `html:"avatar" file:"maxsize=2MB, types=image/png|image/jpeg"`
+//		Docs   []*multipart.FileHeader `html:"docs" file:"maxsize=10MB, types=application/pdf, max=3"`
+//	}
+//
+// The rules are:
+//
+//   - maxsize: maximum size of each file. Accepts the suffixes B, KB, MB and GB.
+//   - types: allowed MIME types separated by '|'. A type like "image/*" allows any subtype.
+//     The type is sniffed from the first bytes of the file, the Content-Type sent
+//     by the client is ignored.
+//   - max: maximum number of files.
+type fileRules struct {
+	maxSize  int64
+	types    []string
+	maxCount int
+}
+
+// fileRulesCache stores the fileRules of each struct type, indexed by field.
+var fileRulesCache sync.Map
+
+type cachedFileRules struct {
+	rules []fileRules
+	err   error
+}
+
+// fileRulesOf returns the fileRules of each field of the struct type t. The tags
+// are parsed the first time and cached. Returns an error if a tag is not valid.
+func fileRulesOf(t reflect.Type) ([]fileRules, error) {
+	if cached, ok := fileRulesCache.Load(t); ok {
+		return cached.(cachedFileRules).rules, cached.(cachedFileRules).err
+	}
+	cached := cachedFileRules{rules: make([]fileRules, t.NumField())}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup(fileTag)
+		if !ok {
+			continue
+		}
+		rules, err := parseFileRules(tag)
+		if err != nil {
+			cached = cachedFileRules{err: fmt.Errorf("invalid file tag of field '%s.%s': %w", t.Name(), field.Name, err)}
+			break
+		}
+		cached.rules[i] = rules
+	}
+	fileRulesCache.Store(t, cached)
+	return cached.rules, cached.err
+}
+
+func parseFileRules(tag string) (fileRules, error) {
+	rules := fileRules{}
+	for rule := range strings.SplitSeq(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		if len(rule) == 0 {
+			continue
+		}
+		name, value, _ := strings.Cut(rule, "=")
+		var err error
+		switch strings.TrimSpace(name) {
+		case "maxsize":
+			rules.maxSize, err = parseByteSize(strings.TrimSpace(value))
+		case "types":
+			for t := range strings.SplitSeq(value, "|") {
+				rules.types = append(rules.types, strings.TrimSpace(t))
+			}
+		case "max":
+			rules.maxCount, err = strconv.Atoi(strings.TrimSpace(value))
+		default:
+			err = fmt.Errorf("unknown rule")
+		}
+		if err != nil {
+			return fileRules{}, fmt.Errorf("rule '%s': %w", rule, err)
+		}
+	}
+	return rules, nil
+}
+
+// parseByteSize parses sizes like "512", "100KB" or "2MB".
+func parseByteSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	upper := strings.ToUpper(value)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+			return n * unit.size, err
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// bindFormFiles sets the uploaded files to the field if they match the rules of the
+// 'file' tag. Otherwise, the errors are added to result and the field is left untouched.
+func bindFormFiles(field reflect.Value, form formData, target formTarget, result *bindResult) {
+	headers := form.files[target.name]
+	if len(headers) == 0 {
+		return
+	}
+	if field.Type() == fileHeaderType {
+		headers = headers[:1]
+	}
+	if !validateFiles(headers, target.fileRules, target, result) {
+		return
+	}
+	if field.Type() == fileHeaderType {
+		field.Set(reflect.ValueOf(headers[0]))
+		return
+	}
+	field.Set(reflect.ValueOf(headers))
+}
+
+func validateFiles(headers []*multipart.FileHeader, rules fileRules, target formTarget, result *bindResult) bool {
+	if rules.maxCount > 0 && len(headers) > rules.maxCount {
+		result.fail(target.path, newFileError(target, FileCountErrorIdentifier, strconv.Itoa(len(headers)), strconv.Itoa(rules.maxCount)))
+		return false
+	}
+	valid := true
+	for _, header := range headers {
+		if rules.maxSize > 0 && header.Size > rules.maxSize {
+			result.fail(target.path, newFileError(target, FileSizeErrorIdentifier, header.Filename, strconv.FormatInt(rules.maxSize, 10)))
+			valid = false
+			continue
+		}
+		if len(rules.types) == 0 {
+			continue
+		}
+		contentType, err := DetectFileType(header)
+		if err != nil || !matchFileType(contentType, rules.types) {
+			result.fail(target.path, newFileError(target, FileTypeErrorIdentifier, header.Filename, strings.Join(rules.types, "|")))
+			valid = false
+		}
+	}
+	return valid
+}
+
+// DetectFileType returns the MIME type of an uploaded file sniffing its first
+// bytes (see http.DetectContentType). The Content-Type sent by the client is not used.
+func DetectFileType(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buffer := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buffer[:n]))
+	return mediaType, err
+}
+
+func matchFileType(contentType string, allowed []string) bool {
+	for _, t := range allowed {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileError is the core.ValidationError added to ModelState when an uploaded file does
+// not match the rules of the 'file' tag. Its identifier is FileSizeErrorIdentifier,
+// FileTypeErrorIdentifier or FileCountErrorIdentifier, so you can localize it adding a
+// translation with the keys "file.size", "file.type" and "file.count". The localized
+// string receives the localized field name:
+//
+//	"file.size": "The file of %s is too big"
+type FileError struct {
+	StructName string
+	FieldName  string
+	// FieldValue is the file name, or the number of files for count errors.
+	FieldValue string
+	// Limit is the rule that was infringed: the max size in bytes, the allowed types or the max count.
+	Limit      string
+	Identifier valtruc.ValidatorIdentifier
+}
+
+func newFileError(target formTarget, identifier valtruc.ValidatorIdentifier, value, limit string) core.ValidationError {
+	return FileError{
+		StructName: target.structName,
+		FieldName:  target.fieldName,
+		FieldValue: value,
+		Limit:      limit,
+		Identifier: identifier,
+	}
+}
+
+func (err FileError) Error() string {
+	switch err.Identifier {
+	case FileSizeErrorIdentifier:
+		return fmt.Sprintf("file '%s' of field '%s' is bigger than %s bytes", err.FieldValue, err.FieldName, err.Limit)
+	case FileTypeErrorIdentifier:
+		return fmt.Sprintf("file '%s' of field '%s' is not of type %s", err.FieldValue, err.FieldName, err.Limit)
+	default:
+		return fmt.Sprintf("field '%s' has %s files. The maximum is %s", err.FieldName, err.FieldValue, err.Limit)
+	}
+}
+
+// Format returns the localized message. If there is no translation, returns Error.
+func (err FileError) Format(f string) string {
+	if len(f) == 0 || strings.HasPrefix(f, string(err.Identifier)+"%!") {
+		return err.Error()
+	}
+	return f
+}
+
+func (err FileError) GetStructName() string                      { return err.StructName }
+func (err FileError) GetFieldName() string                       { return err.FieldName }
+func (err FileError) GetFieldTypeName() string                   { return fileHeaderType.String() }
+func (err FileError) GetFieldValue() string                      { return err.FieldValue }
+func (err FileError) GetIdentifier() valtruc.ValidatorIdentifier { return err.Identifier }
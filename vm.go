@@ -1,6 +1,7 @@
 package owl
 
 import (
+	"fmt"
 	"html/template"
 
 	"github.com/deltegui/owl/core"
@@ -13,6 +14,8 @@ type ViewModel struct {
 	Localizer  localizer.Localizer
 	ModelState core.ModelState
 	CsrfToken  string
+	RequestId  string
+	CspNonce   string
 	Ctx        Ctx
 }
 
@@ -25,6 +28,8 @@ func createViewModel(ctx Ctx, name string, model any) ViewModel {
 	return ViewModel{
 		Model:      model,
 		CsrfToken:  csrfToken,
+		RequestId:  ctx.RequestId(),
+		CspNonce:   ctx.CspNonce(),
 		Localizer:  loc,
 		ModelState: ctx.ModelState,
 		Ctx:        ctx,
@@ -35,6 +40,17 @@ func (vm ViewModel) PlaceCsrfInput() template.HTML {
 	return template.HTML(`<input type="hidden" name="` + csrf.CsrfHeaderName + `" value="` + vm.CsrfToken + `"/>`)
 }
 
+// PlaceCspNonce returns the nonce attribute for inline scripts and styles, or
+// nothing if there is no nonce. See middleware.SecureHeaders.
+//
+//	<script {{ .PlaceCspNonce }}>console.log("allowed")</script>
+func (vm ViewModel) PlaceCspNonce() template.HTMLAttr {
+	if len(vm.CspNonce) == 0 {
+		return ""
+	}
+	return template.HTMLAttr(`nonce="` + vm.CspNonce + `"`)
+}
+
 func (vm ViewModel) Localize(key string, args ...any) string {
 	return vm.Localizer.GetFormatted(key, args...)
 }
@@ -87,6 +103,32 @@ func (vm ViewModel) GetAllFormErrors(key string) []string {
 	return output
 }
 
+// RawValue returns the raw string submitted for a field path, exactly
+// as the user typed it. See ParseForm.
+func (vm ViewModel) RawValue(key string) string {
+	if vm.Ctx.state == nil {
+		return ""
+	}
+	return vm.Ctx.state.binding.raw[key]
+}
+
+// FormValue returns the value to show in a form input. If the submitted value for
+// the field path could not be converted, returns the raw submitted value. Otherwise
+// returns value. This way the user sees again what was typed:
+//
+//	<input type="number" name="Age" value="{{ .FormValue "Age" .Model.Age }}" />
+func (vm ViewModel) FormValue(key string, value any) string {
+	if vm.Ctx.state != nil {
+		if _, failed := vm.Ctx.state.binding.errors[key]; failed {
+			return vm.Ctx.state.binding.raw[key]
+		}
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
 type SelectItem struct {
 	Value    string
 	Tag      string
@@ -0,0 +1,613 @@
+package owl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGuid is used to compute the Sec-WebSocket-Accept header. See RFC 6455 section 4.2.2.
+const websocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	defaultWebSocketMaxMessageSize = 1 << 20 // 1MB
+	websocketCloseTimeout          = 5 * time.Second
+	maxControlPayload              = 125
+)
+
+// Frame opcodes. See RFC 6455 section 5.2.
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+// WebSocketMessageType is the type of a WebSocket message: text or binary.
+type WebSocketMessageType byte
+
+const (
+	TextMessage   = WebSocketMessageType(opText)
+	BinaryMessage = WebSocketMessageType(opBinary)
+)
+
+// WebSocket close codes. See RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// ErrWebSocketClosed is returned when writing to a closed WebSocket.
+var ErrWebSocketClosed = errors.New("websocket is closed")
+
+// WebSocketCloseError is returned by WebSocket.ReadMessage when the connection is
+// closed. It has the code and reason sent by the client or, if the client broke the
+// protocol, the code and reason sent to the client.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (err *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("websocket closed with code %d: %s", err.Code, err.Reason)
+}
+
+func closeError(code int, reason string) *WebSocketCloseError {
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+// WebSocketOptions configures a WebSocket upgrade. See Ctx.UpgradeWebSocket.
+type WebSocketOptions struct {
+	// Subprotocols supported by the server. The first protocol requested by the
+	// client that is in this list is selected.
+	Subprotocols []string
+
+	// MaxMessageSize is the maximum size in bytes of a received message, after
+	// decompressing it. Bigger messages close the connection. By default is 1MB.
+	MaxMessageSize int64
+
+	// Compression enables the permessage-deflate extension (RFC 7692) if the client supports it.
+	Compression bool
+
+	// PingInterval enables sending a ping to the client every interval.
+	PingInterval time.Duration
+
+	// WriteTimeout limits the time of writing a message. Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// CheckOrigin tells if the Origin of the request is allowed. If its nil only requests
+	// without Origin or with the same host (see Ctx.Host) are allowed. See
+	// middleware.WebSocketOrigin.
+	CheckOrigin func(req *http.Request) bool
+
+	// Authorize is called before upgrading the connection. If it returns an error, the
+	// connection is not upgraded and the error is returned by UpgradeWebSocket. See
+	// middleware.WebSocketSession.
+	Authorize func(ctx Ctx) error
+}
+
+// WebSocket is a server side WebSocket connection (RFC 6455). Only one goroutine should
+// read messages at the same time, but writes are safe from different goroutines. Pings
+// from the client are answered automatically while reading.
+type WebSocket struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	subprotocol    string
+	compression    bool
+	maxMessageSize int64
+	writeTimeout   time.Duration
+
+	writeMutex sync.Mutex
+	closeSent  bool
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// UpgradeWebSocket validates the WebSocket handshake and upgrades the connection. After
+// upgrading, the handler must not use ctx.Res. If the handshake is not valid, returns an
+// HttpError (bad request, forbidden or upgrade required) and the connection is not upgraded.
+// For example:
+//
+//	func chat(ctx owl.Ctx) error {
+//		ws, err := ctx.UpgradeWebSocket(owl.WebSocketOptions{
+//			CheckOrigin: middleware.WebSocketOrigin(corsOptions),
+//			Authorize:   middleware.WebSocketSession(sessionManager),
+//		})
+//		if err != nil {
+//			return err
+//		}
+//		defer ws.Close(owl.CloseNormal, "")
+//		for {
+//			_, msg, err := ws.ReadMessage()
+//			if err != nil {
+//				return nil
+//			}
+//			ws.WriteMessage(owl.TextMessage, msg)
+//		}
+//	}
+func (ctx Ctx) UpgradeWebSocket(opt WebSocketOptions) (*WebSocket, error) {
+	req := ctx.Req
+	if req.Method != http.MethodGet {
+		return nil, NewHttpError(http.StatusMethodNotAllowed, errors.New("websocket handshake requires GET method"))
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") || !headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return nil, NewHttpError(http.StatusBadRequest, errors.New("request is not a websocket handshake"))
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.Res.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewHttpError(http.StatusUpgradeRequired, errors.New("unsupported websocket version"))
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, NewHttpError(http.StatusBadRequest, errors.New("invalid Sec-WebSocket-Key"))
+	}
+	checkOrigin := opt.CheckOrigin
+	if checkOrigin == nil {
+		host := ctx.Host()
+		checkOrigin = func(req *http.Request) bool {
+			return isSameOrigin(req, host)
+		}
+	}
+	if !checkOrigin(req) {
+		return nil, NewHttpError(http.StatusForbidden, fmt.Errorf("websocket origin not allowed: %s", req.Header.Get("Origin")))
+	}
+	if opt.Authorize != nil {
+		if err := opt.Authorize(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	ws := &WebSocket{
+		subprotocol:    selectSubprotocol(req.Header, opt.Subprotocols),
+		compression:    opt.Compression && acceptsDeflate(req.Header),
+		maxMessageSize: opt.MaxMessageSize,
+		writeTimeout:   opt.WriteTimeout,
+		closed:         make(chan struct{}),
+	}
+	if ws.maxMessageSize <= 0 {
+		ws.maxMessageSize = defaultWebSocketMaxMessageSize
+	}
+
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&response, "Sec-WebSocket-Accept: %s\r\n", websocketAccept(key))
+	if len(ws.subprotocol) > 0 {
+		fmt.Fprintf(&response, "Sec-WebSocket-Protocol: %s\r\n", ws.subprotocol)
+	}
+	if ws.compression {
+		response.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	response.WriteString("\r\n")
+
+	conn, rw, err := http.NewResponseController(ctx.Res).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("cannot hijack connection for websocket: %w", err)
+	}
+	// Clear the deadlines set by the server timeouts.
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(response.String())); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot write websocket handshake: %w", err)
+	}
+	ws.conn = conn
+	ws.reader = rw.Reader
+	if opt.PingInterval > 0 {
+		go ws.keepAlive(opt.PingInterval)
+	}
+	return ws, nil
+}
+
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGuid))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// isSameOrigin tells if the Origin header, if any, is the host of the request.
+func isSameOrigin(req *http.Request, host string) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// headerValues returns the comma separated values of all the headers with the name.
+func headerValues(header http.Header, name string) []string {
+	var values []string
+	for _, value := range header.Values(name) {
+		for part := range strings.SplitSeq(value, ",") {
+			if part = strings.TrimSpace(part); len(part) > 0 {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range headerValues(header, name) {
+		if strings.EqualFold(value, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func selectSubprotocol(header http.Header, supported []string) string {
+	for _, protocol := range headerValues(header, "Sec-WebSocket-Protocol") {
+		for _, s := range supported {
+			if protocol == s {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// acceptsDeflate tells if the client offers permessage-deflate with parameters
+// the server supports. The server always uses a 32KB window and no context takeover.
+func acceptsDeflate(header http.Header) bool {
+next:
+	for _, offer := range headerValues(header, "Sec-WebSocket-Extensions") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			value = strings.Trim(value, `"`)
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				if value != "15" {
+					continue next
+				}
+			default:
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Subprotocol returns the negotiated subprotocol or empty if there is none.
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// Done is closed when the connection is closed.
+func (ws *WebSocket) Done() <-chan struct{} {
+	return ws.closed
+}
+
+// ReadMessage blocks until a message is received. Returns a *WebSocketCloseError when the
+// connection is closed by the client, or when the client breaks the protocol or sends a
+// message bigger than MaxMessageSize. In both cases, the connection is closed.
+func (ws *WebSocket) ReadMessage() (WebSocketMessageType, []byte, error) {
+	var messageType WebSocketMessageType
+	var compressed bool
+	var message []byte
+	for {
+		frame, err := ws.readFrame(ws.maxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		switch frame.opcode {
+		case opPing:
+			if err := ws.writeFrame(opPong, frame.payload, false); err != nil && !errors.Is(err, ErrWebSocketClosed) {
+				return 0, nil, ws.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, ws.handleClose(frame.payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, ws.fail(closeError(CloseProtocolError, "expected continuation frame"))
+			}
+			messageType = WebSocketMessageType(frame.opcode)
+			compressed = frame.compressed
+		case opContinuation:
+			if messageType == 0 || frame.compressed {
+				return 0, nil, ws.fail(closeError(CloseProtocolError, "unexpected continuation frame"))
+			}
+		default:
+			return 0, nil, ws.fail(closeError(CloseProtocolError, "unknown opcode"))
+		}
+		message = append(message, frame.payload...)
+		if frame.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if message, err = inflate(message, ws.maxMessageSize); err != nil {
+			return 0, nil, ws.fail(err)
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, ws.fail(closeError(CloseInvalidPayload, "invalid utf-8 text message"))
+	}
+	return messageType, message, nil
+}
+
+// ReadJson reads a message and decodes it as Json into dst.
+func (ws *WebSocket) ReadJson(dst any) error {
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(message, dst)
+}
+
+// WriteMessage sends a message to the client. If compression was negotiated, the
+// message is compressed.
+func (ws *WebSocket) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("invalid websocket message type %d", messageType)
+	}
+	if ws.compression {
+		compressed, err := deflate(data)
+		if err != nil {
+			return err
+		}
+		return ws.writeFrame(byte(messageType), compressed, true)
+	}
+	return ws.writeFrame(byte(messageType), data, false)
+}
+
+// WriteText sends a text message.
+func (ws *WebSocket) WriteText(text string) error {
+	return ws.WriteMessage(TextMessage, []byte(text))
+}
+
+// WriteJson sends a text message with the Json representation of data.
+func (ws *WebSocket) WriteJson(data any) error {
+	buffer, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal websocket message: %w", err)
+	}
+	return ws.WriteMessage(TextMessage, buffer)
+}
+
+// Ping sends a ping to the client. The payload cannot be bigger than 125 bytes.
+func (ws *WebSocket) Ping(payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket ping payload too big")
+	}
+	return ws.writeFrame(opPing, payload, false)
+}
+
+// Close starts the close handshake sending a close frame. The connection is closed
+// when the client answers (while reading messages) or after a timeout.
+func (ws *WebSocket) Close(code int, reason string) error {
+	err := ws.writeClose(code, reason)
+	time.AfterFunc(websocketCloseTimeout, ws.closeConn)
+	return err
+}
+
+func (ws *WebSocket) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-ticker.C:
+			if err := ws.Ping(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) closeConn() {
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+		ws.conn.Close()
+	})
+}
+
+// fail closes the connection. Protocol errors are sent to the client in a close frame.
+func (ws *WebSocket) fail(err error) error {
+	var closeErr *WebSocketCloseError
+	if errors.As(err, &closeErr) {
+		ws.writeClose(closeErr.Code, closeErr.Reason)
+	}
+	ws.closeConn()
+	return err
+}
+
+// handleClose answers a close frame of the client and closes the connection.
+func (ws *WebSocket) handleClose(payload []byte) error {
+	code := CloseNoStatus
+	reason := ""
+	switch {
+	case len(payload) == 1:
+		return ws.fail(closeError(CloseProtocolError, "invalid close frame"))
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+		if !utf8.ValidString(reason) {
+			return ws.fail(closeError(CloseInvalidPayload, "invalid utf-8 close reason"))
+		}
+	}
+	if code == CloseNoStatus {
+		ws.writeFrame(opClose, nil, false)
+	} else {
+		ws.writeClose(code, "")
+	}
+	ws.closeConn()
+	return closeError(code, reason)
+}
+
+func (ws *WebSocket) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return ws.writeFrame(opClose, payload, false)
+}
+
+type websocketFrame struct {
+	fin        bool
+	compressed bool
+	opcode     byte
+	payload    []byte
+}
+
+// readFrame reads a frame from the client. Frames bigger than limit are rejected.
+func (ws *WebSocket) readFrame(limit int64) (websocketFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return websocketFrame{}, err
+	}
+	frame := websocketFrame{
+		fin:        header[0]&0x80 != 0,
+		compressed: header[0]&0x40 != 0,
+		opcode:     header[0] & 0x0F,
+	}
+	if header[0]&0x30 != 0 || (frame.compressed && !ws.compression) {
+		return frame, closeError(CloseProtocolError, "unexpected reserved bits")
+	}
+	if header[1]&0x80 == 0 {
+		return frame, closeError(CloseProtocolError, "client frames must be masked")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if frame.opcode >= opClose {
+		if !frame.fin || length > maxControlPayload || frame.compressed {
+			return frame, closeError(CloseProtocolError, "invalid control frame")
+		}
+	} else if length > uint64(max(limit, 0)) {
+		return frame, closeError(CloseMessageTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return frame, err
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, frame.payload); err != nil {
+		return frame, err
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// writeFrame sends a frame to the client. After sending a close frame, only
+// returns ErrWebSocketClosed.
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte, compressed bool) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	if opcode == opClose {
+		ws.closeSent = true
+	}
+
+	first := 0x80 | opcode
+	if compressed {
+		first |= 0x40
+	}
+	frame := []byte{first}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	if ws.writeTimeout > 0 {
+		ws.conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout))
+	}
+	if _, err := ws.conn.Write(frame); err != nil {
+		return err
+	}
+	return nil
+}
+
+// deflateTail is removed from compressed messages and added back before
+// decompressing them. See RFC 7692 section 7.2.
+const deflateTail = "\x00\x00\xff\xff"
+
+func deflate(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte(deflateTail)), nil
+}
+
+func inflate(data []byte, limit int64) ([]byte, error) {
+	// The final empty block avoids an unexpected EOF at the end of the message.
+	input := io.MultiReader(bytes.NewReader(data), strings.NewReader(deflateTail+"\x01\x00\x00\xff\xff"))
+	reader := flate.NewReader(input)
+	defer reader.Close()
+	message, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, closeError(CloseInvalidPayload, "invalid compressed message")
+	}
+	if int64(len(message)) > limit {
+		return nil, closeError(CloseMessageTooBig, "message too big")
+	}
+	return message, nil
+}